@@ -2,9 +2,10 @@ package agent
 
 import (
 	"github.com/icphalanx/agent/hosts/linux"
+	"github.com/icphalanx/agent/reporters"
 	"github.com/icphalanx/agent/types"
 )
 
-func MakeLocalAgent() (types.Host, error) {
-	return linux.Create()
+func MakeLocalAgent(sel reporters.Selection) (types.Host, error) {
+	return linux.Create(sel)
 }
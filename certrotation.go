@@ -0,0 +1,338 @@
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/icphalanx/rpc"
+)
+
+func (r *RPCAgent) currentCert() *tls.Certificate {
+	return r.cert.Load().(*tls.Certificate)
+}
+
+func (r *RPCAgent) setCert(cert *tls.Certificate) error {
+	if cert.Leaf == nil {
+		var err error
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	r.cert.Store(cert)
+	return nil
+}
+
+// getClientCertificate is used as our tls.Config's GetClientCertificate, so
+// that every new handshake picks up the most recently issued certificate.
+func (r *RPCAgent) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.currentCert(), nil
+}
+
+// requestCertificate generates a fresh keypair and asks the collector to sign
+// it, returning the PEM encoded certificate and private key.
+func (r *RPCAgent) requestCertificate(commonName string) (certPem, privPem []byte, err error) {
+	priv, privPem, err := generateKey(r.keyAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr := x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		DNSNames: []string{commonName},
+	}
+	genCsr, err := x509.CreateCertificateRequest(rand.Reader, &csr, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csrPem := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: genCsr,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	signingResp, err := r.rpcClient().SignMe(ctx, &pb.SigningRequest{
+		Csr: string(csrPem),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []byte(signingResp.Cert), privPem, nil
+}
+
+// writeFileAtomic writes b to a temporary file alongside path and returns its
+// name, ready to be renamed into place.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// syncDir flushes renames within dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// while the key is being replaced, the old one is kept alongside it with this
+// suffix, so that we can go back to it if the certificate doesn't follow
+const prevKeySuffix = ".prev"
+
+// persistCertificate replaces the certificate and key on disk. Both files are
+// fully written out before either is renamed into place, and the old key is
+// kept until the new certificate is in place, so that a failure part way
+// through is rolled back here, or by recoverCertificate after a crash.
+func persistCertificate(certPath, privKeyPath string, certPem, privPem []byte) error {
+	certTmp, err := writeFileAtomic(certPath, certPem, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTmp)
+
+	privTmp, err := writeFileAtomic(privKeyPath, privPem, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(privTmp)
+
+	prevKeyPath := privKeyPath + prevKeySuffix
+	hadKey := false
+	if oldPem, err := ioutil.ReadFile(privKeyPath); err == nil {
+		prevTmp, err := writeFileAtomic(prevKeyPath, oldPem, 0600)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(prevTmp, prevKeyPath); err != nil {
+			os.Remove(prevTmp)
+			return err
+		}
+		hadKey = true
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(privTmp, privKeyPath); err != nil {
+		os.Remove(prevKeyPath)
+		return err
+	}
+	if err := os.Rename(certTmp, certPath); err != nil {
+		if hadKey {
+			if rerr := os.Rename(prevKeyPath, privKeyPath); rerr != nil {
+				log.Println("certrenew: failed to restore the previous key:", rerr)
+			}
+		}
+		return err
+	}
+
+	if err := syncDir(filepath.Dir(certPath)); err != nil {
+		return err
+	}
+	if filepath.Dir(privKeyPath) != filepath.Dir(certPath) {
+		if err := syncDir(filepath.Dir(privKeyPath)); err != nil {
+			return err
+		}
+	}
+	if hadKey {
+		return os.Remove(prevKeyPath)
+	}
+	return nil
+}
+
+// recoverCertificate finishes off or undoes a persistCertificate which was
+// interrupted, by checking which key the certificate on disk belongs to.
+func recoverCertificate(certPath, privKeyPath string) error {
+	prevKeyPath := privKeyPath + prevKeySuffix
+	if _, err := os.Stat(prevKeyPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		// we'll be enrolling afresh anyway
+		return os.Remove(prevKeyPath)
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, privKeyPath); err == nil {
+		// the certificate made it, so we're done with the old key
+		return os.Remove(prevKeyPath)
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, prevKeyPath); err != nil {
+		return fmt.Errorf("neither %s nor %s matches %s", privKeyPath, prevKeyPath, certPath)
+	}
+
+	log.Println("certrenew: restoring the key from an interrupted renewal")
+	if err := os.Rename(prevKeyPath, privKeyPath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(privKeyPath))
+}
+
+// verifyIssuedCertificate checks that the certificate the collector handed
+// back is one we can actually use before we commit to it.
+func verifyIssuedCertificate(caCertPool *x509.CertPool, commonName string, certPem, privPem []byte) (tls.Certificate, error) {
+	// this also checks that the certificate matches our private key
+	kp, err := tls.X509KeyPair(certPem, privPem)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(kp.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	kp.Leaf = leaf
+
+	if leaf.Subject.CommonName != commonName {
+		return tls.Certificate{}, fmt.Errorf("issued certificate is for %q, not %q", leaf.Subject.CommonName, commonName)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, der := range kp.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		intermediates.AddCert(c)
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         caCertPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return tls.Certificate{}, fmt.Errorf("issued certificate does not chain to our CA: %v", err)
+	}
+
+	return kp, nil
+}
+
+// renewCertificate obtains a new certificate over the existing connection,
+// verifies it, persists it, and swaps it in for future handshakes. Nothing
+// on disk is touched until the new certificate has been verified.
+func (r *RPCAgent) renewCertificate() error {
+	commonName, err := r.agent.HumanName()
+	if err != nil {
+		return err
+	}
+
+	certPem, privPem, err := r.requestCertificate(commonName)
+	if err != nil {
+		return err
+	}
+
+	kp, err := verifyIssuedCertificate(r.tlsConfig.RootCAs, commonName, certPem, privPem)
+	if err != nil {
+		return err
+	}
+
+	if err := persistCertificate(r.certPath, r.privKeyPath, certPem, privPem); err != nil {
+		return err
+	}
+
+	return r.setCert(&kp)
+}
+
+// the least time we leave between renewals, however short-lived the
+// certificates the collector hands out are
+const minRotationInterval = time.Hour
+
+// rotationInterval is how long to wait after a renewal before renewing again
+// if the new certificate is already inside the rotation window: normally
+// minRotationInterval, but no more than half of what's left of the
+// certificate's lifetime, so that it doesn't expire under us.
+func rotationInterval(notAfter, now time.Time) time.Duration {
+	if half := notAfter.Sub(now) / 2; half < minRotationInterval {
+		return half
+	}
+	return minRotationInterval
+}
+
+// certRotator renews our certificate as it approaches expiry. The live
+// connection (and so the log stream) is left alone; the new certificate is
+// presented the next time we handshake.
+func (r *RPCAgent) certRotator() {
+	bo := newBackoff(time.Minute, 6*time.Hour)
+	var notBefore time.Time
+	for {
+		settings, changed := r.Settings()
+		sleepFor := r.currentCert().Leaf.NotAfter.Sub(time.Now()) - settings.RotationWindow
+		if minSleep := notBefore.Sub(time.Now()); sleepFor < minSleep {
+			sleepFor = minSleep
+		}
+		if sleepFor > 0 {
+			log.Println("certrenew: sleeping for", sleepFor)
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(sleepFor):
+			case <-changed:
+				// the rotation window may have moved, so work it out again
+				continue
+			}
+		}
+
+		log.Println("certrenew: renewing certificate")
+		if err := r.renewCertificate(); err != nil {
+			wait := bo.Next()
+			log.Println("certrenew: failed to renew certificate, retrying in", wait, "-", err)
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		bo.Reset()
+
+		now := time.Now()
+		notAfter := r.currentCert().Leaf.NotAfter
+		log.Println("certrenew: renewed certificate, now valid until", notAfter)
+		if notAfter.Sub(now) <= settings.RotationWindow {
+			notBefore = now.Add(rotationInterval(notAfter, now))
+			log.Println("certrenew: WARNING: the new certificate expires within the rotation window of", settings.RotationWindow, "- not renewing again until", notBefore)
+		}
+	}
+}
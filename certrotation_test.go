@@ -0,0 +1,619 @@
+package agent
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/icphalanx/agent/spool"
+	"github.com/icphalanx/agent/types"
+	pb "github.com/icphalanx/rpc"
+)
+
+const testHostName = "agent.example.com"
+
+var testSerial int64
+
+// testCA is a certificate authority for issuing test certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&testSerial, 1)),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue signs a certificate for pub, returning it PEM encoded.
+func (ca *testCA) issue(cn string, pub crypto.PublicKey, usage x509.ExtKeyUsage, notAfter time.Time) ([]byte, error) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&testSerial, 1)),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if ip := net.ParseIP(cn); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{cn}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// keyPair generates a key and issues a certificate for it.
+func (ca *testCA) keyPair(t *testing.T, cn string, usage x509.ExtKeyUsage, notAfter time.Time) tls.Certificate {
+	priv, privPem, err := generateKey(KEYALGORITHM_ECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem, err := ca.issue(cn, priv.Public(), usage, notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := tls.X509KeyPair(certPem, privPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kp.Leaf, err = x509.ParseCertificate(kp.Certificate[0]); err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+type signMode int
+
+const (
+	SIGN_GOOD = iota
+	SIGN_WRONG_CA
+	SIGN_WRONG_KEY
+	SIGN_WRONG_CN
+)
+
+func (sm signMode) String() string {
+	switch sm {
+	case SIGN_GOOD:
+		return "good"
+	case SIGN_WRONG_CA:
+		return "wrong CA"
+	case SIGN_WRONG_KEY:
+		return "wrong key"
+	case SIGN_WRONG_CN:
+		return "wrong CN"
+	}
+	return "unknown"
+}
+
+// fakeCollector is a PhalanxCollector which only knows how to sign
+// certificates, and can be told to sign them wrongly.
+type fakeCollector struct {
+	// the methods we don't implement panic if called
+	pb.PhalanxCollectorServer
+
+	ca      *testCA
+	otherCA *testCA
+	mode    signMode
+
+	signed int32
+}
+
+func (fc *fakeCollector) SignMe(ctx context.Context, req *pb.SigningRequest) (*pb.SigningResponse, error) {
+	atomic.AddInt32(&fc.signed, 1)
+
+	block, _ := pem.Decode([]byte(req.Csr))
+	if block == nil {
+		return nil, fmt.Errorf("no CSR in request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+
+	ca, cn, pub := fc.ca, csr.Subject.CommonName, csr.PublicKey
+	switch fc.mode {
+	case SIGN_WRONG_CA:
+		ca = fc.otherCA
+	case SIGN_WRONG_KEY:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		pub = key.Public()
+	case SIGN_WRONG_CN:
+		cn = "someone-else.example.com"
+	}
+
+	certPem, err := ca.issue(cn, pub, x509.ExtKeyUsageClientAuth, time.Now().Add(90*24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SigningResponse{Cert: string(certPem)}, nil
+}
+
+func (fc *fakeCollector) signCount() int {
+	return int(atomic.LoadInt32(&fc.signed))
+}
+
+// startFakeCollector serves fc over TLS on a local port, returning its
+// address.
+func startFakeCollector(t *testing.T, fc *fakeCollector) string {
+	serverCert := fc.ca.keyPair(t, "127.0.0.1", x509.ExtKeyUsageServerAuth, time.Now().Add(24*time.Hour))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})))
+	pb.RegisterPhalanxCollectorServer(s, fc)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+type testHost struct{}
+
+func (testHost) Id() string {
+	return "test"
+}
+
+func (testHost) IsLocal() bool {
+	return true
+}
+
+func (testHost) HumanName() (string, error) {
+	return testHostName, nil
+}
+
+func (testHost) Parent() (types.Host, error) {
+	return nil, nil
+}
+
+func (testHost) Reporters() ([]types.Reporter, error) {
+	return nil, nil
+}
+
+type testEnv struct {
+	fc    *fakeCollector
+	dir   string
+	agent *RPCAgent
+}
+
+func (te *testEnv) certPath() string {
+	return filepath.Join(te.dir, "cert.pem")
+}
+
+func (te *testEnv) keyPath() string {
+	return filepath.Join(te.dir, "key.pem")
+}
+
+// newTestEnv starts a fake collector and an RPCAgent talking to it, which
+// presents cert.
+func newTestEnv(t *testing.T, mode signMode, cert func(*testCA) tls.Certificate) *testEnv {
+	te := &testEnv{
+		fc: &fakeCollector{
+			ca:      newTestCA(t, "Phalanx Test CA"),
+			otherCA: newTestCA(t, "Some Other CA"),
+			mode:    mode,
+		},
+		dir: t.TempDir(),
+	}
+	addr := startFakeCollector(t, te.fc)
+
+	sp, err := spool.Open(filepath.Join(te.dir, "spool"), spool.DefaultConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sp.Close() })
+
+	opts := Options{
+		Target:       addr,
+		CertPath:     te.certPath(),
+		PrivKeyPath:  te.keyPath(),
+		KeyAlgorithm: KEYALGORITHM_ECDSA,
+		Settings:     DefaultSettings,
+	}
+	te.agent, err = rpcAgentWithConfig(opts, testHost{}, te.fc.ca.pool, cert(te.fc.ca), sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { te.agent.conn.Close() })
+
+	return te
+}
+
+func provisioningCert(t *testing.T) func(*testCA) tls.Certificate {
+	return func(ca *testCA) tls.Certificate {
+		return ca.keyPair(t, ProvisioningCommonName, x509.ExtKeyUsageClientAuth, time.Now().Add(365*24*time.Hour))
+	}
+}
+
+func ownCert(t *testing.T, validFor time.Duration) func(*testCA) tls.Certificate {
+	return func(ca *testCA) tls.Certificate {
+		return ca.keyPair(t, testHostName, x509.ExtKeyUsageClientAuth, time.Now().Add(validFor))
+	}
+}
+
+type fileState struct {
+	contents []byte
+	mode     os.FileMode
+}
+
+// snapshotDir records every file directly inside dir.
+func snapshotDir(t *testing.T, dir string) map[string]fileState {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]fileState{}
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[fi.Name()] = fileState{b, fi.Mode().Perm()}
+	}
+	return files
+}
+
+func assertDirUnchanged(t *testing.T, dir string, before map[string]fileState) {
+	t.Helper()
+
+	after := snapshotDir(t, dir)
+	for name, fs := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			t.Errorf("%s was created", name)
+		case !bytes.Equal(old.contents, fs.contents):
+			t.Errorf("%s was modified", name)
+		case old.mode != fs.mode:
+			t.Errorf("%s changed mode from %v to %v", name, old.mode, fs.mode)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			t.Errorf("%s was removed", name)
+		}
+	}
+}
+
+func TestRenewCertificateRejectsBadCertificates(t *testing.T) {
+	for _, mode := range []signMode{SIGN_WRONG_CA, SIGN_WRONG_KEY, SIGN_WRONG_CN} {
+		t.Run(mode.String(), func(t *testing.T) {
+			te := newTestEnv(t, mode, ownCert(t, 10*24*time.Hour))
+			oldCert := te.agent.currentCert()
+
+			// the certificate and key we already have must survive
+			if err := ioutil.WriteFile(te.certPath(), []byte("existing certificate"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(te.keyPath(), []byte("existing key"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			before := snapshotDir(t, te.dir)
+
+			if err := te.agent.renewCertificate(); err == nil {
+				t.Fatal("renewCertificate accepted a certificate signed with the", mode)
+			}
+			if te.fc.signCount() != 1 {
+				t.Errorf("SignMe called %d times, want 1", te.fc.signCount())
+			}
+
+			assertDirUnchanged(t, te.dir, before)
+			if te.agent.currentCert() != oldCert {
+				t.Error("current certificate was replaced")
+			}
+		})
+	}
+}
+
+func TestRenewCertificateWritesPrivateFiles(t *testing.T) {
+	te := newTestEnv(t, SIGN_GOOD, ownCert(t, 10*24*time.Hour))
+
+	// replacing world-readable files must still leave private ones
+	if err := ioutil.WriteFile(te.certPath(), []byte("existing certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(te.keyPath(), []byte("existing key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := te.agent.renewCertificate(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := snapshotDir(t, te.dir)
+	if len(files) != 2 {
+		t.Errorf("found %d files, want just the certificate and key", len(files))
+	}
+	for _, path := range []string{te.certPath(), te.keyPath()} {
+		fs, ok := files[filepath.Base(path)]
+		if !ok {
+			t.Fatalf("%s was not written", path)
+		}
+		if fs.mode != 0600 {
+			t.Errorf("%s has mode %v, want 0600", path, fs.mode)
+		}
+	}
+
+	kp, err := tls.LoadX509KeyPair(te.certPath(), te.keyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(kp.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != testHostName {
+		t.Errorf("written certificate is for %q, want %q", leaf.Subject.CommonName, testHostName)
+	}
+	if cn := te.agent.currentCert().Leaf.Subject.CommonName; cn != testHostName {
+		t.Errorf("current certificate is for %q, want %q", cn, testHostName)
+	}
+	if !bytes.Equal(te.agent.currentCert().Certificate[0], kp.Certificate[0]) {
+		t.Error("current certificate is not the one written to disk")
+	}
+}
+
+func TestGetNewCertificateIfNeeded(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		cert func(*testCA) tls.Certificate
+		mode signMode
+
+		wantRenewed bool
+		wantErr     bool
+	}{{
+		name:        "provisioning certificate",
+		cert:        provisioningCert(t),
+		mode:        SIGN_GOOD,
+		wantRenewed: true,
+	}, {
+		name:    "provisioning certificate, bad certificate issued",
+		cert:    provisioningCert(t),
+		mode:    SIGN_WRONG_CN,
+		wantErr: true,
+	}, {
+		name:        "own certificate, not due for renewal",
+		cert:        ownCert(t, 365*24*time.Hour),
+		mode:        SIGN_GOOD,
+		wantRenewed: false,
+	}, {
+		name:        "own certificate, due for renewal",
+		cert:        ownCert(t, DefaultSettings.RenewalWindow/2),
+		mode:        SIGN_GOOD,
+		wantRenewed: true,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			te := newTestEnv(t, test.mode, test.cert)
+
+			tokenPath := filepath.Join(te.dir, "token.pem")
+			if err := ioutil.WriteFile(tokenPath, []byte("token"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			te.agent.enrolmentTokenPath = tokenPath
+			before := snapshotDir(t, te.dir)
+
+			renewed, err := te.agent.getNewCertificateIfNeeded()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("getNewCertificateIfNeeded returned error %v, want error: %v", err, test.wantErr)
+			}
+			if renewed != test.wantRenewed {
+				t.Errorf("getNewCertificateIfNeeded renewed = %v, want %v", renewed, test.wantRenewed)
+			}
+
+			wantSigned := 0
+			if test.wantRenewed || test.wantErr {
+				wantSigned = 1
+			}
+			if te.fc.signCount() != wantSigned {
+				t.Errorf("SignMe called %d times, want %d", te.fc.signCount(), wantSigned)
+			}
+
+			if !test.wantRenewed {
+				// nothing written, and the token kept for next time
+				assertDirUnchanged(t, te.dir, before)
+				return
+			}
+
+			if fileExists(tokenPath) {
+				t.Error("enrolment token was not removed after enrolling")
+			}
+			if !fileExists(te.certPath()) || !fileExists(te.keyPath()) {
+				t.Error("new certificate and key were not written")
+			}
+			if cn := te.agent.currentCert().Leaf.Subject.CommonName; cn != testHostName {
+				t.Errorf("current certificate is for %q, want %q", cn, testHostName)
+			}
+		})
+	}
+}
+
+// pemPair generates a key and issues a certificate for it, PEM encoded.
+func (ca *testCA) pemPair(t *testing.T) (certPem, privPem []byte) {
+	priv, privPem, err := generateKey(KEYALGORITHM_ECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem, err = ca.issue(testHostName, priv.Public(), x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPem, privPem
+}
+
+func writeTestFile(t *testing.T, path string, b []byte) {
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPersistCertificateRollsBackKey(t *testing.T) {
+	ca := newTestCA(t, "Phalanx Test CA")
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert"), filepath.Join(dir, "key.pem")
+
+	_, oldKey := ca.pemPair(t)
+	writeTestFile(t, keyPath, oldKey)
+
+	// a non-empty directory can't be renamed over, so the certificate fails
+	// to go into place after the key has
+	if err := os.MkdirAll(filepath.Join(certPath, "in-the-way"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	newCert, newKey := ca.pemPair(t)
+	if err := persistCertificate(certPath, keyPath, newCert, newKey); err == nil {
+		t.Fatal("persistCertificate succeeded, want an error")
+	}
+
+	b, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, oldKey) {
+		t.Error("key was not rolled back")
+	}
+	if _, err := os.Stat(keyPath + prevKeySuffix); !os.IsNotExist(err) {
+		t.Error("previous key was left behind")
+	}
+}
+
+func TestRecoverCertificate(t *testing.T) {
+	ca := newTestCA(t, "Phalanx Test CA")
+
+	oldCert, oldKey := ca.pemPair(t)
+	newCert, newKey := ca.pemPair(t)
+
+	for _, test := range []struct {
+		name          string
+		cert, key     []byte
+		prevKey       []byte
+		wantKey       []byte
+		wantErr       bool
+		wantPrevAfter bool
+	}{{
+		name:    "interrupted before the certificate was renamed",
+		cert:    oldCert,
+		key:     newKey,
+		prevKey: oldKey,
+		wantKey: oldKey,
+	}, {
+		name:    "interrupted after the certificate was renamed",
+		cert:    newCert,
+		key:     newKey,
+		prevKey: oldKey,
+		wantKey: newKey,
+	}, {
+		name:    "not interrupted",
+		cert:    newCert,
+		key:     newKey,
+		wantKey: newKey,
+	}, {
+		name:    "no certificate yet",
+		key:     newKey,
+		prevKey: oldKey,
+		wantKey: newKey,
+	}, {
+		name:          "neither key matches",
+		cert:          oldCert,
+		key:           newKey,
+		prevKey:       newKey,
+		wantKey:       newKey,
+		wantErr:       true,
+		wantPrevAfter: true,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+			if test.cert != nil {
+				writeTestFile(t, certPath, test.cert)
+			}
+			writeTestFile(t, keyPath, test.key)
+			if test.prevKey != nil {
+				writeTestFile(t, keyPath+prevKeySuffix, test.prevKey)
+			}
+
+			err := recoverCertificate(certPath, keyPath)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, test.wantErr)
+			}
+
+			b, err := ioutil.ReadFile(keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, test.wantKey) {
+				t.Error("ended up with the wrong key")
+			}
+			if _, err := os.Stat(keyPath + prevKeySuffix); os.IsNotExist(err) == test.wantPrevAfter {
+				t.Errorf("previous key left behind: %v, want %v", !os.IsNotExist(err), test.wantPrevAfter)
+			}
+		})
+	}
+}
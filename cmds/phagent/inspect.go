@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/icphalanx/agent"
+	"github.com/icphalanx/agent/config"
+	"github.com/icphalanx/agent/types"
+)
+
+// exit codes for inspect
+const (
+	inspectOK     = 0
+	inspectError  = 1
+	inspectDanger = 2
+)
+
+type inspectMetric struct {
+	Id        string      `json:"id"`
+	HumanName string      `json:"human_name"`
+	HumanDesc string      `json:"human_desc"`
+	Type      string      `json:"type"`
+	Status    string      `json:"status"`
+	Value     interface{} `json:"value"`
+
+	danger bool
+}
+
+type inspectIssue struct {
+	Id          string    `json:"id"`
+	Severity    string    `json:"severity"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Remediation string    `json:"remediation,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+type inspectReporter struct {
+	Id      string          `json:"id"`
+	Metrics []inspectMetric `json:"metrics"`
+	Issues  []inspectIssue  `json:"issues"`
+	Hosts   []inspectHost   `json:"hosts,omitempty"`
+	// anything which went wrong running the reporter
+	Errors []string `json:"errors,omitempty"`
+}
+
+type inspectHost struct {
+	Id        string            `json:"id"`
+	HumanName string            `json:"human_name"`
+	Reporters []inspectReporter `json:"reporters"`
+}
+
+func inspectMetrics(ms []types.Metric) []inspectMetric {
+	ims := make([]inspectMetric, len(ms))
+	for n, m := range ms {
+		ims[n] = inspectMetric{
+			Id:        m.Id(),
+			HumanName: m.HumanName(),
+			HumanDesc: m.HumanDesc(),
+			Type:      m.MetricType().String(),
+			Status:    m.Status().String(),
+			danger:    m.Status() == types.METRICSTATUS_DANGER,
+		}
+		switch m.MetricType() {
+		case types.METRICTYPE_UNCOUNTABLE:
+			ims[n].Value = m.(types.MetricUncountable).Value()
+		case types.METRICTYPE_STRINGARRAY:
+			ims[n].Value = m.(types.MetricStringArray).Value()
+		}
+	}
+	return ims
+}
+
+func inspectIssues(is []types.Issue) []inspectIssue {
+	iis := make([]inspectIssue, len(is))
+	for n, i := range is {
+		iis[n] = inspectIssue{
+			Id:          i.Id(),
+			Severity:    i.Severity().String(),
+			Title:       i.Title(),
+			Description: i.Description(),
+			Remediation: i.Remediation(),
+			FirstSeen:   i.FirstSeen(),
+			LastSeen:    i.LastSeen(),
+		}
+	}
+	return iis
+}
+
+func inspect(h types.Host) (inspectHost, error) {
+	var err error
+	ih := inspectHost{Id: h.Id()}
+
+	if ih.HumanName, err = h.HumanName(); err != nil {
+		return ih, err
+	}
+
+	reporters, err := h.Reporters()
+	if err != nil {
+		return ih, err
+	}
+
+	for _, r := range reporters {
+		ih.Reporters = append(ih.Reporters, inspectOne(r))
+	}
+	return ih, nil
+}
+
+// inspectOne runs a single reporter. Errors are recorded against it, rather
+// than returned, so that one broken reporter doesn't hide the rest.
+func inspectOne(r types.Reporter) inspectReporter {
+	ir := inspectReporter{Id: r.Id()}
+
+	if metrics, err := r.Metrics(); err != nil {
+		ir.Errors = append(ir.Errors, fmt.Sprintf("metrics: %v", err))
+	} else {
+		ir.Metrics = inspectMetrics(metrics)
+	}
+
+	if issues, err := r.Issues(); err != nil {
+		ir.Errors = append(ir.Errors, fmt.Sprintf("issues: %v", err))
+	} else {
+		ir.Issues = inspectIssues(issues)
+	}
+
+	hosts, err := r.Hosts()
+	if err != nil {
+		ir.Errors = append(ir.Errors, fmt.Sprintf("hosts: %v", err))
+	}
+	for _, sh := range hosts {
+		ish, err := inspect(sh)
+		if err != nil {
+			ir.Errors = append(ir.Errors, fmt.Sprintf("host %s: %v", sh.Id(), err))
+			continue
+		}
+		ir.Hosts = append(ir.Hosts, ish)
+	}
+
+	return ir
+}
+
+func (ih inspectHost) anyDanger() bool {
+	for _, ir := range ih.Reporters {
+		for _, im := range ir.Metrics {
+			if im.danger {
+				return true
+			}
+		}
+		for _, ish := range ir.Hosts {
+			if ish.anyDanger() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ih inspectHost) anyErrors() bool {
+	for _, ir := range ih.Reporters {
+		if len(ir.Errors) > 0 {
+			return true
+		}
+		for _, ish := range ir.Hosts {
+			if ish.anyErrors() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ih inspectHost) writeTable(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%sHOST %s (%s)\n", indent, ih.HumanName, ih.Id)
+	for _, ir := range ih.Reporters {
+		fmt.Fprintf(w, "%s  REPORTER %s\n", indent, ir.Id)
+		for _, e := range ir.Errors {
+			fmt.Fprintf(w, "%s    ERROR %s\n", indent, e)
+		}
+
+		if len(ir.Metrics) > 0 {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "%s    METRIC\tSTATUS\tVALUE\tDESCRIPTION\n", indent)
+			for _, im := range ir.Metrics {
+				value := fmt.Sprint(im.Value)
+				if ss, ok := im.Value.([]string); ok {
+					value = strings.Join(ss, ", ")
+				}
+				fmt.Fprintf(tw, "%s    %s\t%s\t%s\t%s\n", indent, im.Id, im.Status, value, im.HumanDesc)
+			}
+			tw.Flush()
+		}
+
+		if len(ir.Issues) > 0 {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "%s    ISSUE\tSEVERITY\tTITLE\tREMEDIATION\n", indent)
+			for _, ii := range ir.Issues {
+				fmt.Fprintf(tw, "%s    %s\t%s\t%s\t%s\n", indent, ii.Id, ii.Severity, ii.Title, ii.Remediation)
+			}
+			tw.Flush()
+		}
+
+		for _, ish := range ir.Hosts {
+			ish.writeTable(w, indent+"    ")
+		}
+	}
+}
+
+// inspectMain implements `phagent inspect`, which runs every reporter once
+// and prints what it finds. It exits with inspectDanger if any metric is in
+// danger, so that it can be used in provisioning checks, or otherwise with
+// inspectError if any reporter failed.
+func inspectMain(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	configLocation := fs.String("config", "", "location of configuration file, used to select and configure reporters")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args)
+
+	c := config.Default()
+	if *configLocation != "" {
+		var err error
+		if c, err = config.Load(*configLocation); err != nil {
+			log.Println(err)
+			return inspectError
+		}
+	}
+
+	h, err := agent.MakeLocalAgent(selectionFromConfig(c))
+	if err != nil {
+		log.Println(err)
+		return inspectError
+	}
+
+	ih, err := inspect(h)
+	agent.CloseReporters(h)
+	if err != nil {
+		log.Println(err)
+		return inspectError
+	}
+
+	switch *format {
+	case "table":
+		ih.writeTable(os.Stdout, "")
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(ih); err != nil {
+			log.Println(err)
+			return inspectError
+		}
+	default:
+		log.Println("unknown format", *format)
+		return inspectError
+	}
+
+	if ih.anyDanger() {
+		return inspectDanger
+	} else if ih.anyErrors() {
+		return inspectError
+	}
+	return inspectOK
+}
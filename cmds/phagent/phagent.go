@@ -3,25 +3,235 @@ package main
 import (
 	"flag"
 	"github.com/icphalanx/agent"
+	"github.com/icphalanx/agent/config"
+	"github.com/icphalanx/agent/promexporter"
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
 	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
 )
 
 var (
-	Upstream = flag.String("upstream", "127.0.0.1:13890", "upstream location")
+	defaults = config.Default()
 
-	CertLocation    = flag.String("certLocation", "phagent.crt", "location to store certificate")
-	PrivKeyLocation = flag.String("privKeyLocation", "phagent.key", "location to store private key")
+	ConfigLocation = flag.String("config", "", "location of configuration file; flags given explicitly override it")
 
-	CALocation = flag.String("caLocation", "phalanx.crt", "location of CA")
+	Upstream = flag.String("upstream", defaults.Upstream, "upstream location")
+
+	CertLocation    = flag.String("certLocation", defaults.TLS.Cert, "location to store certificate")
+	PrivKeyLocation = flag.String("privKeyLocation", defaults.TLS.Key, "location to store private key")
+
+	CALocation = flag.String("caLocation", defaults.TLS.CA, "location of CA")
+
+	KeyAlgorithm = flag.String("keyAlgorithm", defaults.TLS.KeyAlgorithm, "algorithm to use when generating a private key: rsa, ecdsa or ed25519")
+
+	ProvisioningCertLocation = flag.String("provisioningCertLocation", "", "location of the certificate used to enrol with upstream")
+	ProvisioningKeyLocation  = flag.String("provisioningKeyLocation", "", "location of the private key used to enrol with upstream")
+	EnrolmentTokenLocation   = flag.String("enrolmentTokenLocation", "", "location of a one-time enrolment token containing a provisioning certificate and key, deleted after enrolment")
+
+	SpoolLocation = flag.String("spoolLocation", defaults.Spool, "directory to spool log lines in whilst upstream is unreachable")
+
+	Sinks = flag.String("sinks", "", "comma separated list of sinks to send reports and log lines to: rpc, file (default rpc, or file if -output is given)")
+
+	OutputLocation = flag.String("output", "", "file to write reports and log lines to (- for stdout) for the file sink")
+	OutputFormat   = flag.String("outputFormat", defaults.Output.Format, "format to write standalone output in: jsonl or protobuf")
+
+	PrometheusListen = flag.String("prometheusListen", "", "address to serve Prometheus metrics on, e.g. :9105; disabled if empty")
 )
 
+func loadConfig() (*config.Config, error) {
+	c := config.Default()
+	if *ConfigLocation != "" {
+		var err error
+		if c, err = config.Load(*ConfigLocation); err != nil {
+			return nil, err
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "upstream":
+			c.Upstream = *Upstream
+		case "certLocation":
+			c.TLS.Cert = *CertLocation
+		case "privKeyLocation":
+			c.TLS.Key = *PrivKeyLocation
+		case "caLocation":
+			c.TLS.CA = *CALocation
+		case "keyAlgorithm":
+			c.TLS.KeyAlgorithm = *KeyAlgorithm
+		case "provisioningCertLocation":
+			c.TLS.Provisioning.Cert = *ProvisioningCertLocation
+		case "provisioningKeyLocation":
+			c.TLS.Provisioning.Key = *ProvisioningKeyLocation
+		case "enrolmentTokenLocation":
+			c.TLS.Provisioning.EnrolmentToken = *EnrolmentTokenLocation
+		case "spoolLocation":
+			c.Spool = *SpoolLocation
+		case "sinks":
+			c.Sinks = strings.Split(*Sinks, ",")
+		case "output":
+			c.Output.Path = *OutputLocation
+		case "outputFormat":
+			c.Output.Format = *OutputFormat
+		case "prometheusListen":
+			c.PrometheusListen = *PrometheusListen
+		}
+	})
+
+	return c, c.Validate()
+}
+
+func selectionFromConfig(c *config.Config) reporters.Selection {
+	sel := reporters.Selection{
+		Allow:    c.ReporterAllow,
+		Deny:     c.ReporterDenyList(),
+		Options:  map[string]types.ReporterOptions{},
+		Fallback: c.ReporterFallbacks(),
+	}
+	for id, rc := range c.Reporters {
+		sel.Options[id] = types.ReporterOptions(rc.Options)
+	}
+	return sel
+}
+
+func optionsFromConfig(c *config.Config) (agent.Options, error) {
+	keyAlg, err := agent.ParseKeyAlgorithm(c.TLS.KeyAlgorithm)
+	if err != nil {
+		return agent.Options{}, err
+	}
+
+	return agent.Options{
+		Target:       c.Upstream,
+		CAPath:       c.TLS.CA,
+		CertPath:     c.TLS.Cert,
+		PrivKeyPath:  c.TLS.Key,
+		SpoolPath:    c.Spool,
+		KeyAlgorithm: keyAlg,
+		Provisioning: agent.ProvisioningSource{
+			TokenPath: c.TLS.Provisioning.EnrolmentToken,
+			CertPath:  c.TLS.Provisioning.Cert,
+			KeyPath:   c.TLS.Provisioning.Key,
+		},
+		Settings: agent.SettingsFromConfig(c),
+	}, nil
+}
+
+func newSinks(c *config.Config, h types.Host) ([]agent.Sink, error) {
+	sinks := []agent.Sink{}
+	for _, id := range c.SinkIds() {
+		switch id {
+		case "rpc":
+			opts, err := optionsFromConfig(c)
+			if err != nil {
+				return nil, err
+			}
+			s, err := agent.NewRPCAgent(opts, h)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+
+		case "file":
+			format, err := agent.ParseOutputFormat(c.Output.Format)
+			if err != nil {
+				return nil, err
+			}
+			s, err := agent.NewFileSink(c.Output.Path, format)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		}
+	}
+	return sinks, nil
+}
+
+// reloadOnSIGHUP re-reads the configuration whenever we get a SIGHUP. The
+// settings and reporters are updated on the fly; anything else is left alone
+// until the next restart.
+func reloadOnSIGHUP(reporter *agent.Agent, current *config.Config) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	for range ch {
+		log.Println("reload: got SIGHUP, reloading configuration")
+		c, err := loadConfig()
+		if err != nil {
+			log.Println("reload: keeping existing configuration:", err)
+			continue
+		}
+
+		if c.Upstream != current.Upstream || c.TLS != current.TLS || c.Spool != current.Spool || c.Output != current.Output || c.PrometheusListen != current.PrometheusListen {
+			log.Println("reload: upstream, tls, spool, output and prometheus_listen changes will only take effect after a restart")
+		}
+		if !reflect.DeepEqual(c.SinkIds(), current.SinkIds()) {
+			log.Println("reload: sink changes will only take effect after a restart")
+		}
+		if sel := selectionFromConfig(c); !reflect.DeepEqual(sel, selectionFromConfig(current)) {
+			log.Println("reload: re-creating reporters whose configuration changed")
+			reporter.ReloadReporters(sel)
+		}
+
+		reporter.UpdateSettings(agent.SettingsFromConfig(c))
+		current = c
+	}
+}
+
+// stopOnSignal stops the agent when we're asked to exit, so that reporters
+// and sinks get a chance to clean up.
+func stopOnSignal(reporter *agent.Agent) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-ch
+	log.Println("got", sig, "- shutting down")
+	reporter.Stop()
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(inspectMain(os.Args[2:]))
+	}
+
 	flag.Parse()
 
-	reporter, err := agent.NewRPCAgent(*Upstream, *CALocation, *CertLocation, *PrivKeyLocation)
+	c, err := loadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	h, err := agent.MakeLocalAgent(selectionFromConfig(c))
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	log.Fatalln(reporter.Run())
+	sinks, err := newSinks(c, h)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	reporter := agent.NewAgent(h, agent.SettingsFromConfig(c), sinks...)
+
+	go reloadOnSIGHUP(reporter, c)
+	go stopOnSignal(reporter)
+
+	if c.PrometheusListen != "" {
+		go func() {
+			log.Println("serving Prometheus metrics on", c.PrometheusListen)
+			// carry on reporting to the sinks even if we can't serve metrics
+			err := promexporter.ListenAndServe(c.PrometheusListen, reporter)
+			log.Println("promexporter: stopped serving metrics:", err)
+		}()
+	}
+
+	err = reporter.Run()
+	agent.CloseReporters(h)
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
@@ -0,0 +1,219 @@
+// Package config handles phagent's configuration file.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Duration is a time.Duration which is written as a string (e.g. "60s") in
+// the configuration file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(pd)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+type ProvisioningConfig struct {
+	Cert           string `yaml:"cert"`
+	Key            string `yaml:"key"`
+	EnrolmentToken string `yaml:"enrolment_token"`
+}
+
+type TLSConfig struct {
+	CA           string `yaml:"ca"`
+	Cert         string `yaml:"cert"`
+	Key          string `yaml:"key"`
+	KeyAlgorithm string `yaml:"key_algorithm"`
+
+	Provisioning ProvisioningConfig `yaml:"provisioning"`
+}
+
+type OutputConfig struct {
+	// where the file sink writes reports and log lines ("-" for stdout)
+	Path string `yaml:"path"`
+	// jsonl or protobuf
+	Format string `yaml:"format"`
+}
+
+// FallbackReporters maps reporters which, unless enabled explicitly in
+// reporters or reporter_allow, only run when another reporter doesn't.
+// syslogsocket sees the same messages as journald, so running both would send
+// every syslog line twice; it's still wanted on hosts where journald isn't
+// available to us.
+var FallbackReporters = map[string]string{"syslogsocket": "journald"}
+
+type ReporterConfig struct {
+	// nil means "use the default", which is enabled, or enabled only as a
+	// fallback for reporters in FallbackReporters
+	Enabled *bool                  `yaml:"enabled"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+type Config struct {
+	Upstream string    `yaml:"upstream"`
+	TLS      TLSConfig `yaml:"tls"`
+	Spool    string    `yaml:"spool"`
+
+	// where to send reports and log lines: any of "rpc" and "file". If
+	// empty, this is "file" if output.path is set and "rpc" otherwise.
+	Sinks  []string     `yaml:"sinks"`
+	Output OutputConfig `yaml:"output"`
+
+	// if set, serve reporter metrics for Prometheus on this address
+	PrometheusListen string `yaml:"prometheus_listen"`
+
+	// how often we send a report upstream
+	TickInterval Duration `yaml:"tick_interval"`
+	// we re-enrol at startup if our certificate expires within this window
+	RenewalWindow Duration `yaml:"renewal_window"`
+	// we rotate our certificate in the background once it expires within
+	// this window
+	RotationWindow Duration `yaml:"rotation_window"`
+
+	// if non-empty, only these reporters are started
+	ReporterAllow []string `yaml:"reporter_allow"`
+	// these reporters are never started
+	ReporterDeny []string `yaml:"reporter_deny"`
+
+	Reporters map[string]ReporterConfig `yaml:"reporters"`
+}
+
+// Default returns the configuration used when no file is given.
+func Default() *Config {
+	return &Config{
+		Upstream: "127.0.0.1:13890",
+		TLS: TLSConfig{
+			CA:           "phalanx.crt",
+			Cert:         "phagent.crt",
+			Key:          "phagent.key",
+			KeyAlgorithm: "ecdsa",
+		},
+		Spool: "phagent.spool",
+		Output: OutputConfig{
+			Format: "jsonl",
+		},
+
+		TickInterval:   Duration(60 * time.Second),
+		RenewalWindow:  Duration(30 * 24 * time.Hour),
+		RotationWindow: Duration(20 * 24 * time.Hour),
+
+		Reporters: map[string]ReporterConfig{},
+	}
+}
+
+// Load reads the configuration file at path, filling in anything it doesn't
+// mention from Default.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case c.Upstream == "":
+		return fmt.Errorf("upstream must be set")
+	case c.TLS.CA == "":
+		return fmt.Errorf("tls.ca must be set")
+	case c.TLS.Cert == "" || c.TLS.Key == "":
+		return fmt.Errorf("tls.cert and tls.key must be set")
+	case (c.TLS.Provisioning.Cert == "") != (c.TLS.Provisioning.Key == ""):
+		return fmt.Errorf("tls.provisioning.cert and tls.provisioning.key must be set together")
+	case c.Spool == "":
+		return fmt.Errorf("spool must be set")
+	case c.TickInterval <= 0:
+		return fmt.Errorf("tick_interval must be positive")
+	case c.RenewalWindow <= 0 || c.RotationWindow <= 0:
+		return fmt.Errorf("renewal_window and rotation_window must be positive")
+	case c.RotationWindow > c.RenewalWindow:
+		return fmt.Errorf("rotation_window must not be longer than renewal_window")
+	}
+
+	for _, id := range c.SinkIds() {
+		switch id {
+		case "rpc":
+		case "file":
+			if c.Output.Path == "" {
+				return fmt.Errorf("output.path must be set to use the file sink")
+			}
+		default:
+			return fmt.Errorf("unknown sink %q", id)
+		}
+	}
+	return nil
+}
+
+// SinkIds returns the sinks to use, taking the default into account.
+func (c *Config) SinkIds() []string {
+	switch {
+	case len(c.Sinks) > 0:
+		return c.Sinks
+	case c.Output.Path != "":
+		return []string{"file"}
+	}
+	return []string{"rpc"}
+}
+
+// ReporterDenyList returns ReporterDeny along with every reporter which has
+// been explicitly disabled.
+func (c *Config) ReporterDenyList() []string {
+	deny := append([]string{}, c.ReporterDeny...)
+	for id, rc := range c.Reporters {
+		if rc.Enabled != nil && !*rc.Enabled {
+			deny = append(deny, id)
+		}
+	}
+	sort.Strings(deny)
+	return deny
+}
+
+// ReporterFallbacks returns those of FallbackReporters which haven't been
+// explicitly enabled, mapped to the reporter each stands in for.
+func (c *Config) ReporterFallbacks() map[string]string {
+	fallbacks := map[string]string{}
+	for id, primary := range FallbackReporters {
+		if rc := c.Reporters[id]; rc.Enabled == nil && !contains(c.ReporterAllow, id) {
+			fallbacks[id] = primary
+		}
+	}
+	return fallbacks
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
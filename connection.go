@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc/connectivity"
+
+	pb "github.com/icphalanx/rpc"
+)
+
+type ConnectionState uint32
+
+const (
+	CONNSTATE_CONNECTING = iota
+	CONNSTATE_READY
+	CONNSTATE_DISCONNECTED
+)
+
+func (cs ConnectionState) String() string {
+	switch cs {
+	case CONNSTATE_CONNECTING:
+		return "connecting"
+	case CONNSTATE_READY:
+		return "ready"
+	case CONNSTATE_DISCONNECTED:
+		return "disconnected"
+	}
+	return "unknown"
+}
+
+// backoff hands out jittered, exponentially increasing delays between
+// reconnection attempts.
+type backoff struct {
+	base time.Duration
+	max  time.Duration
+
+	attempt uint
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max}
+}
+
+func (b *backoff) Next() time.Duration {
+	d := b.base << b.attempt
+	if d <= 0 || d > b.max {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+
+	// pick somewhere between half and all of d, so that a fleet of agents
+	// which lost their collector at the same time don't all come back at once
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
+
+// State returns the state of the connection used for Report calls.
+func (r *RPCAgent) State() ConnectionState {
+	return ConnectionState(atomic.LoadUint32(&r.state))
+}
+
+// LogStreamState returns the state of the RecordLogs stream.
+func (r *RPCAgent) LogStreamState() ConnectionState {
+	return ConnectionState(atomic.LoadUint32(&r.logStreamState))
+}
+
+func (r *RPCAgent) setState(cs ConnectionState) {
+	if old := ConnectionState(atomic.SwapUint32(&r.state, uint32(cs))); old != cs {
+		log.Println("connection: state changed from", old, "to", cs)
+	}
+}
+
+func (r *RPCAgent) setLogStreamState(cs ConnectionState) {
+	if old := ConnectionState(atomic.SwapUint32(&r.logStreamState, uint32(cs))); old != cs {
+		log.Println("loglinehandler: stream state changed from", old, "to", cs)
+	}
+}
+
+// rpcClient returns the current client, which may be replaced by redial.
+func (r *RPCAgent) rpcClient() pb.PhalanxCollectorClient {
+	r.connMu.RLock()
+	defer r.connMu.RUnlock()
+	return r.client
+}
+
+// redial replaces the underlying connection if gRPC has given up on it.
+func (r *RPCAgent) redial() error {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+
+	select {
+	case <-r.stop:
+		// Close has already closed the connection for good
+		return ErrClosed
+	default:
+	}
+
+	switch r.conn.GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+	default:
+		// gRPC is still trying (or has succeeded) by itself
+		return nil
+	}
+
+	log.Println("connection: redialling", r.target)
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+
+	r.conn.Close()
+	r.conn = conn
+	r.client = pb.NewPhalanxCollectorClient(conn)
+	return nil
+}
+
+// reconnect re-establishes our session with the collector after a failure,
+// re-sending our configuration in case the collector has restarted, and then
+// catching it up with the most recent report.
+func (r *RPCAgent) reconnect() error {
+	r.setState(CONNSTATE_CONNECTING)
+
+	if err := r.redial(); err != nil {
+		return err
+	}
+	if err := r.init(); err != nil {
+		return err
+	}
+	if rep, ok := r.lastReport.Load().(*pb.ReportRequest); ok {
+		if err := r.report(rep); err != nil {
+			return err
+		}
+	}
+
+	r.setState(CONNSTATE_READY)
+	return nil
+}
+
+// connectionLoop (re)connects to the collector whenever we aren't connected,
+// backing off between attempts.
+func (r *RPCAgent) connectionLoop() {
+	bo := newBackoff(time.Second, 5*time.Minute)
+	for {
+		if err := r.reconnect(); err != nil {
+			wait := bo.Next()
+			log.Println("connection: failed to reconnect, retrying in", wait, "-", err)
+			r.setState(CONNSTATE_DISCONNECTED)
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		bo.Reset()
+
+		// wait for Report to tell us we've lost the connection
+		select {
+		case <-r.stop:
+			return
+		case <-r.disconnected:
+		}
+		log.Println("connection: lost connection to upstream")
+	}
+}
+
+func (r *RPCAgent) openLogStream() (pb.PhalanxCollector_RecordLogsClient, error) {
+	r.setLogStreamState(CONNSTATE_CONNECTING)
+	stream, err := r.rpcClient().RecordLogs(context.Background())
+	if err != nil {
+		r.setLogStreamState(CONNSTATE_DISCONNECTED)
+		return nil, err
+	}
+	r.setLogStreamState(CONNSTATE_READY)
+	return stream, nil
+}
@@ -3,9 +3,12 @@ package agent
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
+	"fmt"
 )
 
+// the CommonName of the certificate we use to enrol ourselves
+const ProvisioningCommonName = `Phalanx Provisioning Certificate`
+
 const ProvisioningCertificate = `-----BEGIN CERTIFICATE-----
 xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
 xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx
@@ -58,26 +61,16 @@ yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy
 yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy
 -----END RSA PRIVATE KEY-----`
 
+// LoadEmbeddedCertPair loads the provisioning certificate compiled into the
+// binary. It is only used if no other ProvisioningSource is configured.
 func LoadEmbeddedCertPair() (tls.Certificate, error) {
-	certDec, rest := pem.Decode(ProvisioningCertificate)
-	if certDec == nil {
-		return nil, fmt.Errorf("ProvisioningCertificate corrupted?")
-	}
-
-	keyDec, rest := pem.Decode(ProvisioningKey)
-	if keyDec == nil {
-		return nil, fmt.Errorf("ProvisioningKey corrupted?")
-	}
-
-	privKey, err := x509.ParsePKIXPublicKey(keyDec.Bytes)
+	// X509KeyPair takes care of decoding the PKCS#1 key and checking that it
+	// actually belongs to the certificate
+	cert, err := tls.X509KeyPair([]byte(ProvisioningCertificate), []byte(ProvisioningKey))
 	if err != nil {
-		return nil, err
-	}
-
-	cert := tls.Certificate{
-		Certificate: [][]byte{certDec.Bytes},
-		PrivateKey:  privKey,
+		return tls.Certificate{}, fmt.Errorf("embedded provisioning certificate corrupted? %v", err)
 	}
 
-	return cert, nil
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, err
 }
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"io"
+	"os"
+
+	pb "github.com/icphalanx/rpc"
+)
+
+// FileSink writes reports and log lines to a file (or stdout). This is useful
+// for debugging reporters, feeding other pipelines, and getting data off
+// hosts which can't reach a collector.
+type FileSink struct {
+	path string
+	out  *outputWriter
+	f    io.Closer
+}
+
+// NewFileSink creates a sink writing to path ("-" for stdout) in the given
+// format.
+func NewFileSink(path string, format OutputFormat) (*FileSink, error) {
+	var (
+		w   io.WriteCloser = os.Stdout
+		err error
+	)
+	if path != "-" {
+		w, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &FileSink{
+		path: path,
+		out:  newOutputWriter(w, format),
+		f:    w,
+	}, nil
+}
+
+func (*FileSink) Id() string {
+	return "file"
+}
+
+func (*FileSink) Start() error {
+	return nil
+}
+
+func (fs *FileSink) Report(rep *pb.ReportRequest) error {
+	return fs.out.WriteReport(rep)
+}
+
+func (fs *FileSink) LogLine(ll *pb.LogLine) error {
+	return fs.out.WriteLogLine(ll)
+}
+
+func (fs *FileSink) Close() error {
+	return fs.f.Close()
+}
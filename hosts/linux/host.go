@@ -2,39 +2,69 @@ package linux
 
 import (
 	"github.com/icphalanx/agent/reporters"
+	_ "github.com/icphalanx/agent/reporters/filetail"
+	_ "github.com/icphalanx/agent/reporters/fsusage"
+	_ "github.com/icphalanx/agent/reporters/journald"
+	_ "github.com/icphalanx/agent/reporters/netstat"
+	_ "github.com/icphalanx/agent/reporters/netsyslog"
 	_ "github.com/icphalanx/agent/reporters/packagekit"
+	_ "github.com/icphalanx/agent/reporters/procfs"
+	_ "github.com/icphalanx/agent/reporters/reboot"
 	_ "github.com/icphalanx/agent/reporters/syslogsocket"
+	_ "github.com/icphalanx/agent/reporters/systemd"
 	"github.com/icphalanx/agent/types"
 	"os"
+	"sync"
 )
 
 type LinuxHost struct {
+	mu        sync.RWMutex
+	sel       reporters.Selection
 	reporters []types.Reporter
 }
 
-func (LinuxHost) Id() string {
+func (*LinuxHost) Id() string {
 	return "linux"
 }
 
-func (LinuxHost) IsLocal() bool {
+func (*LinuxHost) IsLocal() bool {
 	return true
 }
 
-func (LinuxHost) HumanName() (string, error) {
+func (*LinuxHost) HumanName() (string, error) {
 	return os.Hostname()
 }
 
-func (LinuxHost) Parent() (types.Host, error) {
+func (*LinuxHost) Parent() (types.Host, error) {
 	return nil, nil
 }
 
 func (lh *LinuxHost) Reporters() ([]types.Reporter, error) {
+	lh.mu.RLock()
+	defer lh.mu.RUnlock()
 	return lh.reporters, nil
 }
 
-func Create() (types.Host, error) {
-	lh := new(LinuxHost)
-	lh.reporters = reporters.GenerateFor(lh)
+// Reload closes and re-creates the reporters which sel enables, disables or
+// configures differently, returning the reporters it created.
+func (lh *LinuxHost) Reload(sel reporters.Selection) ([]types.Reporter, error) {
+	lh.mu.Lock()
+	defer lh.mu.Unlock()
+
+	affected := lh.sel.Affected(sel)
+	if len(affected) == 0 {
+		return nil, nil
+	}
+
+	var created []types.Reporter
+	lh.reporters, created = reporters.RegenerateFor(lh, lh.reporters, sel, affected)
+	lh.sel = sel
+	return created, nil
+}
+
+func Create(sel reporters.Selection) (types.Host, error) {
+	lh := &LinuxHost{sel: sel}
+	lh.reporters = reporters.GenerateFor(lh, sel)
 
 	return lh, nil
 }
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+type KeyAlgorithm string
+
+const (
+	KEYALGORITHM_RSA     KeyAlgorithm = "rsa"
+	KEYALGORITHM_ECDSA   KeyAlgorithm = "ecdsa"
+	KEYALGORITHM_ED25519 KeyAlgorithm = "ed25519"
+)
+
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	switch ka := KeyAlgorithm(s); ka {
+	case KEYALGORITHM_RSA, KEYALGORITHM_ECDSA, KEYALGORITHM_ED25519:
+		return ka, nil
+	}
+	return "", fmt.Errorf("unknown key algorithm %q", s)
+}
+
+// generateKey creates a new private key of the given algorithm, returning it
+// along with its PKCS#8 PEM encoding.
+func generateKey(ka KeyAlgorithm) (crypto.Signer, []byte, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+
+	switch ka {
+	case KEYALGORITHM_RSA:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case KEYALGORITHM_ECDSA:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KEYALGORITHM_ED25519:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unknown key algorithm %q", ka)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privAsn, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privPem := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: privAsn,
+	})
+	return priv, privPem, nil
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/icphalanx/rpc"
+)
+
+type OutputFormat string
+
+const (
+	// one JSON object per line, with the message under a "report" or
+	// "log_line" key
+	OUTPUTFORMAT_JSONL OutputFormat = "jsonl"
+
+	// a stream of records, each a single type byte (OUTPUTRECORD_*)
+	// followed by a varint length prefixed protobuf message
+	OUTPUTFORMAT_PROTOBUF OutputFormat = "protobuf"
+)
+
+const (
+	OUTPUTRECORD_REPORT  = 1 // pb.ReportRequest
+	OUTPUTRECORD_LOGLINE = 2 // pb.LogLine
+)
+
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch of := OutputFormat(s); of {
+	case OUTPUTFORMAT_JSONL, OUTPUTFORMAT_PROTOBUF:
+		return of, nil
+	}
+	return "", fmt.Errorf("unknown output format %q", s)
+}
+
+// outputWriter writes reports and log lines to w in one of the
+// OutputFormats.
+type outputWriter struct {
+	w      io.Writer
+	format OutputFormat
+	jm     jsonpb.Marshaler
+}
+
+func newOutputWriter(w io.Writer, format OutputFormat) *outputWriter {
+	return &outputWriter{
+		w:      w,
+		format: format,
+		jm:     jsonpb.Marshaler{OrigName: true},
+	}
+}
+
+func (ow *outputWriter) write(recordType byte, jsonKey string, msg proto.Message) error {
+	switch ow.format {
+	case OUTPUTFORMAT_JSONL:
+		var buf bytes.Buffer
+		if err := ow.jm.Marshal(&buf, msg); err != nil {
+			return err
+		}
+		b, err := json.Marshal(map[string]json.RawMessage{jsonKey: buf.Bytes()})
+		if err != nil {
+			return err
+		}
+		_, err = ow.w.Write(append(b, '\n'))
+		return err
+
+	case OUTPUTFORMAT_PROTOBUF:
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		rec := append([]byte{recordType}, proto.EncodeVarint(uint64(len(b)))...)
+		_, err = ow.w.Write(append(rec, b...))
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", ow.format)
+}
+
+func (ow *outputWriter) WriteReport(rep *pb.ReportRequest) error {
+	return ow.write(OUTPUTRECORD_REPORT, "report", rep)
+}
+
+func (ow *outputWriter) WriteLogLine(ll *pb.LogLine) error {
+	return ow.write(OUTPUTRECORD_LOGLINE, "log_line", ll)
+}
@@ -0,0 +1,109 @@
+// Package promexporter exposes reporter metrics for Prometheus to scrape.
+package promexporter
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/icphalanx/agent/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricLabels = []string{"host", "reporter", "metric"}
+
+	valueDesc = prometheus.NewDesc(
+		"phalanx_metric_value",
+		"The current value of a numeric reporter metric.",
+		metricLabels, nil,
+	)
+	infoDesc = prometheus.NewDesc(
+		"phalanx_metric_info",
+		"Always 1; one series for each entry in a string list reporter metric.",
+		append(metricLabels, "value"), nil,
+	)
+	statusDesc = prometheus.NewDesc(
+		"phalanx_metric_status",
+		"The status of a reporter metric: 0 none, 1 healthy, 2 warning, 3 danger.",
+		metricLabels, nil,
+	)
+)
+
+// A Source hands out the snapshot taken on the agent's last tick.
+type Source interface {
+	LastSnapshot() *types.Snapshot
+}
+
+// Collector is a prometheus.Collector which serves the metrics from every
+// reporter on a host, and on any hosts those reporters know about, as of the
+// last tick. Scrapes never ask the reporters themselves, so they don't
+// disturb reporters which measure between one call and the next.
+type Collector struct {
+	source Source
+}
+
+func NewCollector(src Source) *Collector {
+	return &Collector{source: src}
+}
+
+// Describe sends nothing, making this an unchecked collector: the set of
+// metrics depends on what the reporters returned.
+func (*Collector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if s := c.source.LastSnapshot(); s != nil {
+		collectSnapshot(ch, s)
+	}
+}
+
+func collectSnapshot(ch chan<- prometheus.Metric, s *types.Snapshot) {
+	hn, err := s.Host.HumanName()
+	if err != nil {
+		log.Println("promexporter: failed to get name for", s.Host.Id(), err)
+		return
+	}
+
+	for _, rs := range s.Reporters {
+		for _, m := range rs.Metrics {
+			collectMetric(ch, hn, rs.Id, m)
+		}
+		for _, hs := range rs.Hosts {
+			collectSnapshot(ch, hs)
+		}
+	}
+}
+
+func collectMetric(ch chan<- prometheus.Metric, host, reporter string, m types.Metric) {
+	labels := []string{host, reporter, m.Id()}
+
+	switch m.MetricType() {
+	case types.METRICTYPE_UNCOUNTABLE:
+		ch <- prometheus.MustNewConstMetric(valueDesc, prometheus.GaugeValue, float64(m.(types.MetricUncountable).Value()), labels...)
+	case types.METRICTYPE_STRINGARRAY:
+		seen := map[string]bool{}
+		for _, v := range m.(types.MetricStringArray).Value() {
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, 1, append(labels, v)...)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(statusDesc, prometheus.GaugeValue, float64(m.Status()), labels...)
+}
+
+// Handler returns an http.Handler serving the metrics from src.
+func Handler(src Source) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(src))
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves the metrics from src on /metrics at addr.
+func ListenAndServe(addr string, src Source) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(src))
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,74 @@
+package promexporter
+
+import (
+	"testing"
+
+	"github.com/icphalanx/agent/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testHost struct{}
+
+func (testHost) Id() string                           { return "linux" }
+func (testHost) IsLocal() bool                        { return true }
+func (testHost) HumanName() (string, error)           { return "web1", nil }
+func (testHost) Parent() (types.Host, error)          { return nil, nil }
+func (testHost) Reporters() ([]types.Reporter, error) { return nil, nil }
+
+type snapshotSource struct{ s *types.Snapshot }
+
+func (ss snapshotSource) LastSnapshot() *types.Snapshot {
+	return ss.s
+}
+
+func TestCollectLabelsHostsByName(t *testing.T) {
+	src := snapshotSource{&types.Snapshot{
+		Host: testHost{},
+		Reporters: []types.ReporterSnapshot{{
+			Id:      "procfs",
+			Metrics: []types.Metric{types.NewGaugeMetric("load", "Load", "", 3)},
+		}},
+	}}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(src))
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, mf := range mfs {
+		if mf.GetName() != "phalanx_metric_value" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "host" {
+					found = true
+					if lp.GetValue() != "web1" {
+						t.Errorf("host label is %q, want the host's name", lp.GetValue())
+					}
+				}
+			}
+			if v := m.GetGauge().GetValue(); v != 3 {
+				t.Errorf("value is %v, want 3", v)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("no phalanx_metric_value series")
+	}
+}
+
+func TestCollectBeforeFirstTick(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector(snapshotSource{}))
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 0 {
+		t.Errorf("got %d metric families before the first tick, want none", len(mfs))
+	}
+}
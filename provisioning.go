@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const (
+	// environment variables which may hold a PEM encoded provisioning
+	// certificate and key
+	ProvisioningCertEnv = "PHAGENT_PROVISIONING_CERT"
+	ProvisioningKeyEnv  = "PHAGENT_PROVISIONING_KEY"
+)
+
+// ProvisioningSource describes where to find the credential used to enrol
+// with the collector for the first time. Sources are tried in the order:
+// enrolment token, certificate/key paths, environment, and finally the pair
+// embedded in the binary.
+type ProvisioningSource struct {
+	// a file containing both the PEM encoded certificate and key, which is
+	// deleted once we have successfully enrolled
+	TokenPath string
+
+	CertPath string
+	KeyPath  string
+}
+
+// Load returns the provisioning certificate, along with the path of the
+// enrolment token it came from (if any) so that it can be removed later.
+func (ps ProvisioningSource) Load() (tls.Certificate, string, error) {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+
+	switch {
+	case ps.TokenPath != "" && fileExists(ps.TokenPath):
+		log.Println("provisioning: using enrolment token", ps.TokenPath)
+		var b []byte
+		if b, err = ioutil.ReadFile(ps.TokenPath); err != nil {
+			return tls.Certificate{}, "", err
+		}
+		// the token holds both blocks, and X509KeyPair skips over whichever
+		// one it isn't looking for
+		cert, err = tls.X509KeyPair(b, b)
+		if err != nil {
+			return tls.Certificate{}, "", err
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		return cert, ps.TokenPath, err
+
+	case ps.CertPath != "" || ps.KeyPath != "":
+		log.Println("provisioning: using certificate", ps.CertPath)
+		cert, err = tls.LoadX509KeyPair(ps.CertPath, ps.KeyPath)
+
+	case os.Getenv(ProvisioningCertEnv) != "" || os.Getenv(ProvisioningKeyEnv) != "":
+		log.Println("provisioning: using certificate from", ProvisioningCertEnv)
+		cert, err = tls.X509KeyPair([]byte(os.Getenv(ProvisioningCertEnv)), []byte(os.Getenv(ProvisioningKeyEnv)))
+
+	default:
+		log.Println("provisioning: using embedded certificate")
+		cert, err = LoadEmbeddedCertPair()
+		return cert, "", err
+	}
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	return cert, "", err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
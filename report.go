@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"io"
+	"log"
+
+	"github.com/icphalanx/agent/types"
+	pb "github.com/icphalanx/rpc"
+)
+
+// buildReport gathers a report on h from its reporters, along with any extra
+// reporters the caller wants to include.
+func buildReport(h types.Host, extra ...types.Reporter) (*types.Snapshot, *pb.ReportRequest, error) {
+	s, err := types.TakeSnapshot(h, extra...)
+	if err != nil {
+		return nil, nil, err
+	}
+	rep, err := types.SnapshotToRPC(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, rep, nil
+}
+
+// forwardLogLines copies the log lines from each of h's reporters into ch.
+func forwardLogLines(h types.Host, ch chan<- types.ReporterLogLine) error {
+	reporters, err := h.Reporters()
+	if err != nil {
+		return err
+	}
+
+	forwardReporterLogLines(reporters, ch)
+	return nil
+}
+
+func forwardReporterLogLines(reporters []types.Reporter, ch chan<- types.ReporterLogLine) {
+	for _, reporter := range reporters {
+		llc := reporter.LogLines()
+		if llc == nil {
+			continue
+		}
+		go func(llc <-chan types.ReporterLogLine) {
+			for ll := range llc {
+				ch <- ll
+			}
+		}(llc)
+	}
+}
+
+// reporterWithChanges is implemented by reporters which can tell when
+// something they report on has changed, so that it's worth reporting again
+// without waiting for the next tick.
+type reporterWithChanges interface {
+	Changed() <-chan struct{}
+}
+
+// forwardChanges pokes ch whenever any of h's reporters say something has
+// changed. ch should be buffered: if it's already been poked, further pokes
+// are dropped, since one report will cover them all.
+func forwardChanges(h types.Host, ch chan<- struct{}) error {
+	reporters, err := h.Reporters()
+	if err != nil {
+		return err
+	}
+
+	forwardReporterChanges(reporters, ch)
+	return nil
+}
+
+func forwardReporterChanges(reporters []types.Reporter, ch chan<- struct{}) {
+	for _, reporter := range reporters {
+		rwc, ok := reporter.(reporterWithChanges)
+		if !ok {
+			continue
+		}
+		go func(changed <-chan struct{}) {
+			for range changed {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}(rwc.Changed())
+	}
+}
+
+// CloseReporters closes any of h's reporters which hold on to resources,
+// such as sockets, that should be cleaned up before we exit.
+func CloseReporters(h types.Host) {
+	reporters, err := h.Reporters()
+	if err != nil {
+		log.Println("failed to get reporters to close:", err)
+		return
+	}
+
+	for _, r := range reporters {
+		if c, ok := r.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Println(r.Id(), "failed to close:", err)
+			}
+		}
+	}
+}
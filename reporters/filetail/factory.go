@@ -0,0 +1,102 @@
+package filetail
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(FileTailReporterFactory{})
+}
+
+type FileTailReporterFactory struct{}
+
+func (FileTailReporterFactory) Id() string {
+	return "filetail"
+}
+
+func (ftrf FileTailReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := ftrf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	// e.g. /var/log/myapp/*.log
+	patterns, err := opts.Strings("paths", nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no paths configured")
+	}
+
+	statePath, err := opts.String("state_path", "phagent.filetail.state")
+	if err != nil {
+		return nil, err
+	}
+
+	// lines matching this start a new entry; any others are joined on to the
+	// previous one (e.g. for stack traces)
+	multilineStart, err := opts.String("multiline_start", "")
+	if err != nil {
+		return nil, err
+	}
+	var multiline *regexp.Regexp
+	if multilineStart != "" {
+		if multiline, err = regexp.Compile(multilineStart); err != nil {
+			return nil, err
+		}
+	}
+
+	multilineTimeout, err := opts.Duration("multiline_timeout", defaultMultilineTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	pollInterval, err := opts.Duration("poll_interval", defaultPollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	// for files we have never seen before when we start up, whether to ship
+	// what's already in them or only what gets written from now on
+	fromBeginning, err := opts.Bool("from_beginning", false)
+	if err != nil {
+		return nil, err
+	}
+
+	// longer lines are split into pieces of this size
+	maxLineBytes, err := opts.Int("max_line_bytes", defaultMaxLineBytes)
+	if err != nil {
+		return nil, err
+	}
+	if maxLineBytes < 1 {
+		return nil, fmt.Errorf("max_line_bytes must be positive")
+	}
+
+	ftr := &FileTailReporter{
+		host:             h,
+		patterns:         patterns,
+		statePath:        statePath,
+		multiline:        multiline,
+		multilineTimeout: multilineTimeout,
+		pollInterval:     pollInterval,
+		fromBeginning:    fromBeginning,
+		maxLineBytes:     maxLineBytes,
+		tailers:          map[string]*tailer{},
+		left:             map[uint64]int64{},
+	}
+	if ftr.state, err = loadState(statePath); err != nil {
+		return nil, err
+	}
+	return ftr, nil
+}
+
+func (FileTailReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// we can only tail our own files
+	return h.IsLocal(), nil
+}
@@ -0,0 +1,212 @@
+package filetail
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+const (
+	defaultPollInterval     = time.Second
+	defaultMultilineTimeout = 2 * time.Second
+	defaultMaxLineBytes     = 64 << 10
+
+	// how often we write our offsets to disk
+	saveStateInterval = 5 * time.Second
+)
+
+// FileTailReporter ships lines appended to files matching a set of globs,
+// following them across rotation.
+type FileTailReporter struct {
+	host types.Host
+
+	patterns         []string
+	statePath        string
+	multiline        *regexp.Regexp
+	multilineTimeout time.Duration
+	pollInterval     time.Duration
+	fromBeginning    bool
+	maxLineBytes     int
+
+	mu      sync.Mutex
+	tailers map[string]*tailer
+	// offsets loaded at startup, and updated as we save
+	state map[string]fileState
+	// how far we got through files we've stopped following, by inode, in
+	// case a pattern matches them under their new name after rotation
+	left map[uint64]int64
+}
+
+func (*FileTailReporter) Id() string {
+	return "filetail"
+}
+
+func (*FileTailReporter) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (*FileTailReporter) Metrics() ([]types.Metric, error) {
+	return []types.Metric{}, nil
+}
+
+func (*FileTailReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+// following reports whether we're already following the file with inode,
+// under any name.
+func (ftr *FileTailReporter) following(inode uint64) bool {
+	for _, t := range ftr.tailers {
+		if t.f != nil && t.inode == inode {
+			return true
+		}
+	}
+	return false
+}
+
+// startOffset decides where to start reading a newly matching file. Files are
+// known by inode rather than name, so that once e.g. app.log has been rotated
+// to app.log.1 a pattern matching both doesn't ship it all over again.
+func (ftr *FileTailReporter) startOffset(path string, inode uint64, startup bool) int64 {
+	if off, ok := ftr.left[inode]; ok && inode != 0 {
+		return off
+	}
+	if st, ok := ftr.state[path]; ok && st.Inode == inode {
+		return st.Offset
+	}
+	for _, st := range ftr.state {
+		if st.Inode == inode && inode != 0 {
+			// rotated whilst we weren't running
+			return st.Offset
+		}
+	}
+	if _, ok := ftr.state[path]; !ok && startup && !ftr.fromBeginning {
+		return -1
+	}
+	return 0
+}
+
+// rescan starts following any newly matching files.
+func (ftr *FileTailReporter) rescan(startup bool) map[string]bool {
+	matched := map[string]bool{}
+	for _, pattern := range ftr.patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Println("filetail: bad pattern", pattern, err)
+			continue
+		}
+		for _, path := range paths {
+			matched[path] = true
+		}
+	}
+
+	inodes := map[uint64]bool{}
+	for path := range matched {
+		inode := currentInode(path)
+		inodes[inode] = true
+
+		if _, ok := ftr.tailers[path]; ok || ftr.following(inode) {
+			continue
+		}
+
+		t := &tailer{
+			path:             path,
+			multiline:        ftr.multiline,
+			multilineTimeout: ftr.multilineTimeout,
+			maxLineBytes:     ftr.maxLineBytes,
+			leave: func(st fileState) {
+				ftr.left[st.Inode] = st.Offset
+			},
+		}
+
+		if err := t.open(ftr.startOffset(path, inode, startup)); err != nil {
+			log.Println("filetail: failed to open", path, err)
+			continue
+		}
+
+		log.Println("filetail: following", path, "from offset", t.readOff)
+		ftr.tailers[path] = t
+	}
+
+	// forget files which have gone for good
+	for inode := range ftr.left {
+		if !inodes[inode] {
+			delete(ftr.left, inode)
+		}
+	}
+	return matched
+}
+
+// poll collects new lines from every file we're following, then stops
+// following any which no longer match.
+func (ftr *FileTailReporter) poll(matched map[string]bool) []types.ReporterLogLine {
+	var lines []types.ReporterLogLine
+	for path, t := range ftr.tailers {
+		tags := []string{"path-" + path}
+		t.poll(func(line string) {
+			lines = append(lines, types.ReporterLogLine{
+				Host:      ftr.host,
+				Reporter:  ftr,
+				LogLine:   line,
+				Tags:      tags,
+				Timestamp: time.Now(),
+			})
+		})
+
+		if !matched[path] {
+			log.Println("filetail: no longer following", path)
+			t.close()
+			delete(ftr.tailers, path)
+		}
+	}
+	return lines
+}
+
+func (ftr *FileTailReporter) saveState() error {
+	ftr.mu.Lock()
+	state := make(map[string]fileState, len(ftr.tailers))
+	for path, t := range ftr.tailers {
+		state[path] = t.state()
+	}
+	ftr.state = state
+	ftr.mu.Unlock()
+
+	return saveState(ftr.statePath, state)
+}
+
+func (ftr *FileTailReporter) LogLines() <-chan types.ReporterLogLine {
+	s := make(chan types.ReporterLogLine)
+	go func() {
+		ftr.mu.Lock()
+		ftr.rescan(true)
+		ftr.mu.Unlock()
+
+		lastSave := time.Now()
+		for range time.Tick(ftr.pollInterval) {
+			ftr.mu.Lock()
+			lines := ftr.poll(ftr.rescan(false))
+			ftr.mu.Unlock()
+
+			for _, line := range lines {
+				s <- line
+			}
+
+			if time.Since(lastSave) > saveStateInterval {
+				if err := ftr.saveState(); err != nil {
+					log.Println("filetail: failed to save offsets:", err)
+				}
+				lastSave = time.Now()
+			}
+		}
+	}()
+	return s
+}
+
+// Close saves our offsets.
+func (ftr *FileTailReporter) Close() error {
+	return ftr.saveState()
+}
@@ -0,0 +1,88 @@
+package filetail
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestReporter(pattern string) *FileTailReporter {
+	return &FileTailReporter{
+		patterns:     []string{pattern},
+		maxLineBytes: defaultMaxLineBytes,
+		tailers:      map[string]*tailer{},
+		state:        map[string]fileState{},
+		left:         map[uint64]int64{},
+	}
+}
+
+// step rescans and polls once, returning the lines shipped.
+func (ftr *FileTailReporter) step() []string {
+	var lines []string
+	for _, ll := range ftr.poll(ftr.rescan(false)) {
+		lines = append(lines, ll.LogLine)
+	}
+	return lines
+}
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatedFileIsNotReshipped(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	ftr := newTestReporter(filepath.Join(dir, "*.log*"))
+
+	appendFile(t, path, "one\ntwo\n")
+	if got, want := ftr.step(), []string{"one", "two"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("shipped %q, want %q", got, want)
+	}
+
+	// written just before rotation, then rotated by rename
+	appendFile(t, path, "three\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "four\n")
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, ftr.step()...)
+	}
+	if want := []string{"three", "four"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("shipped %q after rotation, want %q", got, want)
+	}
+
+	// the old file is still followed under its new name
+	appendFile(t, path+".1", "late\n")
+	if got, want := ftr.step(), []string{"late"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("shipped %q, want %q", got, want)
+	}
+}
+
+func TestLongLinesAreSplit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	ftr := newTestReporter(path)
+	ftr.maxLineBytes = 10
+
+	appendFile(t, path, strings.Repeat("x", 25)+"\nshort\n"+strings.Repeat("y", 10))
+	want := []string{"xxxxxxxxxx", "xxxxxxxxxx", "xxxxx", "short", "yyyyyyyyyy"}
+	if got := ftr.step(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("shipped %q, want %q", got, want)
+	}
+	if n := len(ftr.tailers[path].partial); n != 0 {
+		t.Errorf("holding on to %d bytes of partial line", n)
+	}
+}
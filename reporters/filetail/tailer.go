@@ -0,0 +1,240 @@
+package filetail
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	readChunkSize = 32 << 10
+
+	// the most lines we'll join into a single multi-line entry
+	maxMultilineLines = 1000
+)
+
+// entry is a (possibly multi-line) log entry which hasn't been emitted yet.
+type entry struct {
+	lines  []string
+	endOff int64
+}
+
+// tailer follows a single path, coping with the file at that path being
+// renamed away (and replaced) or truncated in place.
+type tailer struct {
+	path string
+
+	f       *os.File
+	inode   uint64
+	readOff int64  // how far through f we've read
+	partial []byte // the start of a line we haven't seen the end of
+
+	// lines longer than this are split, so that a file without newlines
+	// can't make us hold on to all of it
+	maxLineBytes int
+
+	// called with how far we got through a file when we stop following it,
+	// so that we can carry on from there if it turns up under another name
+	leave func(fileState)
+
+	multiline        *regexp.Regexp
+	multilineTimeout time.Duration
+	pending          *entry
+	lastLine         time.Time
+
+	// the offset just past the last line we emitted
+	committed int64
+}
+
+func inodeOf(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
+
+func currentInode(path string) uint64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return inodeOf(fi)
+}
+
+// open starts following the current file at t.path, from offset; a negative
+// offset means the current end of the file.
+func (t *tailer) open(offset int64) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if offset < 0 {
+		offset = fi.Size()
+	} else if offset > fi.Size() {
+		// it's been truncated since we last saw it
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	t.f = f
+	t.inode = inodeOf(fi)
+	t.readOff = offset
+	t.committed = offset
+	t.partial = nil
+	return nil
+}
+
+func (t *tailer) close() {
+	if t.f != nil {
+		if t.leave != nil {
+			t.leave(t.state())
+		}
+		t.f.Close()
+		t.f = nil
+	}
+}
+
+func (t *tailer) state() fileState {
+	return fileState{Inode: t.inode, Offset: t.committed}
+}
+
+// poll emits anything new in the file.
+func (t *tailer) poll(emit func(string)) {
+	if t.f == nil {
+		if err := t.open(0); err != nil {
+			return
+		}
+	}
+
+	fi, err := os.Stat(t.path)
+	switch {
+	case err != nil:
+		// the file has gone, perhaps mid-rotation; keep reading what we have
+		t.readAvailable(emit)
+
+	case inodeOf(fi) != t.inode:
+		// rotated by rename: finish off the old file, then start on the new
+		log.Println("filetail:", t.path, "was rotated")
+		t.readAvailable(emit)
+		t.flushPartial(emit)
+		t.flushPending(emit)
+		t.close()
+		if err := t.open(0); err != nil {
+			log.Println("filetail: failed to reopen", t.path, err)
+			return
+		}
+		t.readAvailable(emit)
+
+	case fi.Size() < t.readOff:
+		// rotated by copytruncate
+		log.Println("filetail:", t.path, "was truncated")
+		t.flushPending(emit)
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			log.Println("filetail: failed to rewind", t.path, err)
+			return
+		}
+		t.readOff, t.committed, t.partial = 0, 0, nil
+		t.readAvailable(emit)
+
+	default:
+		t.readAvailable(emit)
+	}
+
+	if t.pending != nil && time.Since(t.lastLine) > t.multilineTimeout {
+		t.flushPending(emit)
+	}
+}
+
+func (t *tailer) readAvailable(emit func(string)) {
+	buf := make([]byte, readChunkSize)
+	for {
+		n, err := t.f.Read(buf)
+		if n > 0 {
+			t.consume(buf[:n], emit)
+		}
+		if err == io.EOF || n == 0 {
+			return
+		} else if err != nil {
+			log.Println("filetail: failed to read", t.path, err)
+			return
+		}
+	}
+}
+
+func (t *tailer) consume(b []byte, emit func(string)) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 || len(t.partial)+i > t.maxLineBytes {
+			n := len(b)
+			if room := t.maxLineBytes - len(t.partial); n > room {
+				n = room
+			}
+			t.partial = append(t.partial, b[:n]...)
+			t.readOff += int64(n)
+			b = b[n:]
+
+			if len(t.partial) >= t.maxLineBytes {
+				log.Println("filetail: line in", t.path, "is over", t.maxLineBytes, "bytes, splitting it")
+				t.flushPartial(emit)
+			}
+			continue
+		}
+
+		line := string(append(t.partial, b[:i]...))
+		t.partial = nil
+		t.readOff += int64(i + 1)
+		b = b[i+1:]
+
+		t.line(strings.TrimSuffix(line, "\r"), emit)
+	}
+}
+
+// flushPartial emits a trailing line with no newline; used when we know no
+// more will be written to the file.
+func (t *tailer) flushPartial(emit func(string)) {
+	if len(t.partial) > 0 {
+		line := string(t.partial)
+		t.partial = nil
+		t.line(line, emit)
+	}
+}
+
+func (t *tailer) line(line string, emit func(string)) {
+	t.lastLine = time.Now()
+
+	if t.multiline == nil {
+		emit(line)
+		t.committed = t.readOff
+		return
+	}
+
+	if t.pending == nil || t.multiline.MatchString(line) || len(t.pending.lines) >= maxMultilineLines {
+		t.flushPending(emit)
+		t.pending = &entry{}
+	}
+	t.pending.lines = append(t.pending.lines, line)
+	t.pending.endOff = t.readOff
+}
+
+func (t *tailer) flushPending(emit func(string)) {
+	if t.pending == nil {
+		return
+	}
+	emit(strings.Join(t.pending.lines, "\n"))
+	t.committed = t.pending.endOff
+	t.pending = nil
+}
@@ -0,0 +1,91 @@
+package fsusage
+
+import (
+	"os"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(FSUsageReporterFactory{})
+}
+
+// filesystems which don't take up any space on anything that can fill up
+var defaultIgnoreFSTypes = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs",
+	"debugfs", "devpts", "devtmpfs", "efivarfs", "fusectl", "hugetlbfs",
+	"mqueue", "nsfs", "overlay", "proc", "pstore", "ramfs", "rpc_pipefs",
+	"securityfs", "squashfs", "sysfs", "tmpfs", "tracefs",
+}
+
+type FSUsageReporterFactory struct{}
+
+func (FSUsageReporterFactory) Id() string {
+	return "fsusage"
+}
+
+func (fsrf FSUsageReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := fsrf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	fsr := &FSUsageReporter{
+		mountInfoPath: "/proc/self/mountinfo",
+		fstabPath:     "/etc/fstab",
+		issues:        types.NewIssueTracker(h),
+		statting:      map[string]bool{},
+	}
+
+	var err error
+	if fsr.bytesThreshold, err = opts.Threshold("bytes", types.Threshold{Warning: 80, Danger: 90}); err != nil {
+		return nil, err
+	}
+	if fsr.inodesThreshold, err = opts.Threshold("inodes", types.Threshold{Warning: 80, Danger: 90}); err != nil {
+		return nil, err
+	}
+
+	ignoreFSTypes, err := opts.Strings("ignore_fstypes", defaultIgnoreFSTypes)
+	if err != nil {
+		return nil, err
+	}
+	fsr.ignoreFSTypes = toSet(ignoreFSTypes)
+
+	ignoreMountPoints, err := opts.Strings("ignore_mountpoints", nil)
+	if err != nil {
+		return nil, err
+	}
+	fsr.ignoreMountPoints = toSet(ignoreMountPoints)
+
+	expectedReadOnly, err := opts.Strings("expected_readonly", nil)
+	if err != nil {
+		return nil, err
+	}
+	fsr.expectedReadOnly = toSet(expectedReadOnly)
+
+	return fsr, nil
+}
+
+func toSet(ss []string) map[string]bool {
+	set := make(map[string]bool, len(ss))
+	for _, s := range ss {
+		set[s] = true
+	}
+	return set
+}
+
+func (FSUsageReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// is this a LinuxHost?
+	if !h.IsLocal() {
+		return false, nil
+	}
+
+	// can we see what's mounted?
+	if _, err := os.Stat("/proc/self/mountinfo"); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,210 @@
+package fsusage
+
+import (
+	"log"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+// how long we wait for statfs before giving up on a mount, e.g. a hung NFS
+// server
+const statfsTimeout = 5 * time.Second
+
+// FSUsageReporter reports space and inode usage for each mounted filesystem.
+type FSUsageReporter struct {
+	mountInfoPath string
+	fstabPath     string
+
+	bytesThreshold  types.Threshold
+	inodesThreshold types.Threshold
+
+	ignoreFSTypes     map[string]bool
+	ignoreMountPoints map[string]bool
+	expectedReadOnly  map[string]bool
+
+	issues *types.IssueTracker
+
+	mu sync.Mutex
+	// mount points with a statfs still outstanding
+	statting map[string]bool
+}
+
+func (*FSUsageReporter) Id() string {
+	return "fsusage"
+}
+
+func (*FSUsageReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (*FSUsageReporter) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+// mounts lists the mounts we're interested in, skipping pseudo filesystems
+// and further mounts of the same device.
+func (fsr *FSUsageReporter) mounts() ([]Mount, error) {
+	all, err := readMountInfo(fsr.mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	mounts := []Mount{}
+	for _, m := range all {
+		if fsr.ignoreFSTypes[m.FSType] || fsr.ignoreMountPoints[m.MountPoint] || seen[m.Device] {
+			continue
+		}
+		seen[m.Device] = true
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+func (fsr *FSUsageReporter) statfs(path string) (*syscall.Statfs_t, error) {
+	fsr.mu.Lock()
+	if fsr.statting[path] {
+		fsr.mu.Unlock()
+		return nil, syscall.EBUSY
+	}
+	fsr.statting[path] = true
+	fsr.mu.Unlock()
+
+	type result struct {
+		st  syscall.Statfs_t
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var r result
+		r.err = syscall.Statfs(path, &r.st)
+
+		fsr.mu.Lock()
+		delete(fsr.statting, path)
+		fsr.mu.Unlock()
+
+		ch <- r
+	}()
+
+	select {
+	case r := <-ch:
+		return &r.st, r.err
+	case <-time.After(statfsTimeout):
+		return nil, syscall.ETIMEDOUT
+	}
+}
+
+func percent(n, d uint64) int {
+	if d == 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(n) * 100 / float64(d)))
+}
+
+func (fsr *FSUsageReporter) Metrics() ([]types.Metric, error) {
+	mounts, err := fsr.mounts()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []types.Metric{}
+	for _, m := range mounts {
+		st, err := fsr.statfs(m.MountPoint)
+		if err != nil {
+			log.Println("fsusage: failed to statfs", m.MountPoint, err)
+			continue
+		}
+		if st.Blocks == 0 {
+			// not backed by anything
+			continue
+		}
+
+		mp := m.MountPoint
+		bsize := uint64(st.Bsize)
+		used := (st.Blocks - st.Bfree) * bsize
+		avail := st.Bavail * bsize
+
+		// like df, count the space reserved for root as neither used nor
+		// available
+		metrics = append(metrics,
+			types.NewGaugeMetric(
+				"bytes_used:"+mp,
+				"Space used on "+mp,
+				"The space used on the filesystem mounted at "+mp+", in bytes.",
+				int(used),
+			),
+			types.NewGaugeMetric(
+				"bytes_free:"+mp,
+				"Space free on "+mp,
+				"The space available to unprivileged users on the filesystem mounted at "+mp+", in bytes.",
+				int(avail),
+			),
+			types.NewThresholdMetric(
+				"bytes_used_percent:"+mp,
+				"Space utilisation on "+mp,
+				"The percentage of the space on the filesystem mounted at "+mp+" which is in use.",
+				percent(used, used+avail),
+				&fsr.bytesThreshold,
+			),
+		)
+
+		if st.Files == 0 {
+			// some filesystems (e.g. btrfs) allocate inodes dynamically
+			continue
+		}
+		metrics = append(metrics,
+			types.NewGaugeMetric(
+				"inodes_used:"+mp,
+				"Inodes used on "+mp,
+				"The number of inodes used on the filesystem mounted at "+mp+".",
+				int(st.Files-st.Ffree),
+			),
+			types.NewGaugeMetric(
+				"inodes_free:"+mp,
+				"Inodes free on "+mp,
+				"The number of inodes free on the filesystem mounted at "+mp+".",
+				int(st.Ffree),
+			),
+			types.NewThresholdMetric(
+				"inodes_used_percent:"+mp,
+				"Inode utilisation on "+mp,
+				"The percentage of the inodes on the filesystem mounted at "+mp+" which are in use.",
+				percent(st.Files-st.Ffree, st.Files),
+				&fsr.inodesThreshold,
+			),
+		)
+	}
+	return metrics, nil
+}
+
+func (fsr *FSUsageReporter) Issues() ([]types.Issue, error) {
+	mounts, err := fsr.mounts()
+	if err != nil {
+		return nil, err
+	}
+
+	// only mounts which fstab says should be writable can be unexpectedly
+	// read-only
+	fstab, err := fstabReadOnly(fsr.fstabPath)
+	if os.IsNotExist(err) {
+		fstab = map[string]bool{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	issues := []types.IssueDetails{}
+	for _, m := range mounts {
+		fstabReadOnly, inFstab := fstab[m.MountPoint]
+		if !m.ReadOnly || !inFstab || fstabReadOnly || fsr.expectedReadOnly[m.MountPoint] {
+			continue
+		}
+		issues = append(issues, readOnlyIssue(m))
+	}
+
+	return fsr.issues.Issues(issues, time.Now()), nil
+}
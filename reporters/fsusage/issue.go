@@ -0,0 +1,20 @@
+package fsusage
+
+import (
+	"fmt"
+
+	"github.com/icphalanx/agent/types"
+)
+
+// readOnlyIssue is raised when a filesystem which fstab says should be
+// writable is mounted read-only, usually because the kernel remounted it
+// after an I/O error.
+func readOnlyIssue(m Mount) types.IssueDetails {
+	return types.IssueDetails{
+		Id:          "readonly:" + m.MountPoint,
+		Severity:    types.ISSUESEVERITY_CRITICAL,
+		Title:       fmt.Sprintf("%s is mounted read-only", m.MountPoint),
+		Description: fmt.Sprintf("The %s filesystem on %s, mounted at %s, is read-only, but /etc/fstab says it should be writable. Filesystems are usually remounted read-only by the kernel after it sees errors from the underlying device.", m.FSType, m.Source, m.MountPoint),
+		Remediation: fmt.Sprintf("Check the kernel log for errors from %s and check the filesystem before remounting it read-write. If %s is meant to be read-only, add it to this reporter's expected_readonly option.", m.Source, m.MountPoint),
+	}
+}
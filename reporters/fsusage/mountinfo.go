@@ -0,0 +1,119 @@
+package fsusage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mount is a line of /proc/self/mountinfo.
+type Mount struct {
+	Device     string // major:minor
+	MountPoint string
+	FSType     string
+	Source     string
+	ReadOnly   bool
+}
+
+// unescapeOctal undoes the \NNN escaping the kernel applies to spaces and
+// the like in paths.
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func hasOption(opts, opt string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountInfoLine parses e.g.
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (Mount, error) {
+	fields := strings.Fields(line)
+
+	sep := -1
+	for n := 6; n < len(fields); n++ {
+		if fields[n] == "-" {
+			sep = n
+			break
+		}
+	}
+	if len(fields) < 6 || sep < 0 || sep+2 >= len(fields) {
+		return Mount{}, fmt.Errorf("malformed mountinfo line %q", line)
+	}
+
+	return Mount{
+		Device:     fields[2],
+		MountPoint: unescapeOctal(fields[4]),
+		FSType:     fields[sep+1],
+		Source:     unescapeOctal(fields[sep+2]),
+		// either the mount itself or the whole filesystem can be read-only
+		ReadOnly: hasOption(fields[5], "ro") || (sep+3 < len(fields) && hasOption(fields[sep+3], "ro")),
+	}, nil
+}
+
+func readMountInfo(path string) ([]Mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []Mount
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		m, err := parseMountInfoLine(sc.Text())
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, sc.Err()
+}
+
+// fstabReadOnly reads which mount points /etc/fstab says should be mounted
+// read-only (true) or read-write (false).
+func fstabReadOnly(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fstab := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		fstab[unescapeOctal(fields[1])] = hasOption(fields[3], "ro")
+	}
+	return fstab, sc.Err()
+}
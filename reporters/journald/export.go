@@ -0,0 +1,70 @@
+package journald
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// the largest binary field we're prepared to read
+const maxFieldSize = 16 << 20
+
+// Entry is a single journal entry, mapping field names to values.
+type Entry map[string]string
+
+// ExportReader reads entries in the journal export format, as produced by
+// `journalctl -o export`. See
+// https://www.freedesktop.org/wiki/Software/systemd/export/
+type ExportReader struct {
+	br *bufio.Reader
+}
+
+func NewExportReader(r io.Reader) *ExportReader {
+	return &ExportReader{bufio.NewReader(r)}
+}
+
+// Next returns the next entry, or io.EOF once there are no more.
+func (er *ExportReader) Next() (Entry, error) {
+	e := Entry{}
+	for {
+		line, err := er.br.ReadBytes('\n')
+		if err == io.EOF && len(e) == 0 && len(line) == 0 {
+			return nil, io.EOF
+		} else if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		line = line[:len(line)-1]
+
+		// entries are separated by a blank line
+		if len(line) == 0 {
+			if len(e) > 0 {
+				return e, nil
+			}
+			continue
+		}
+
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			e[string(line[:i])] = string(line[i+1:])
+			continue
+		}
+
+		// fields which aren't plain text are written as the field name, a
+		// little-endian 64-bit length, the data and then a newline
+		var n uint64
+		if err := binary.Read(er.br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		if n > maxFieldSize {
+			return nil, fmt.Errorf("field %s is too large (%d bytes)", line, n)
+		}
+		data := make([]byte, n+1)
+		if _, err := io.ReadFull(er.br, data); err != nil {
+			return nil, err
+		}
+		e[string(line)] = string(data[:n])
+	}
+}
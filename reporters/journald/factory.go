@@ -0,0 +1,81 @@
+package journald
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(JournaldReporterFactory{})
+}
+
+type JournaldReporterFactory struct{}
+
+func (JournaldReporterFactory) Id() string {
+	return "journald"
+}
+
+func (jrf JournaldReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := jrf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	journalctl, err := journalctlPath(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cursorPath, err := opts.String("cursor_path", "phagent.journald.cursor")
+	if err != nil {
+		return nil, err
+	}
+
+	// e.g. _SYSTEMD_UNIT=sshd.service, passed straight to journalctl
+	matches, err := opts.Strings("matches", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	jr := &JournaldReporter{
+		host:       h,
+		journalctl: journalctl,
+		matches:    matches,
+		cursorPath: cursorPath,
+	}
+	if err := jr.loadCursor(); err != nil {
+		return nil, err
+	}
+	return jr, nil
+}
+
+func (JournaldReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// is this a LinuxHost?
+	if !h.IsLocal() {
+		return false, nil
+	}
+
+	// is journald running?
+	if _, err := os.Stat("/run/systemd/journal"); err != nil {
+		return false, nil
+	}
+
+	if _, err := journalctlPath(opts); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// journalctlPath finds the journalctl we've been configured to use.
+func journalctlPath(opts types.ReporterOptions) (string, error) {
+	journalctl, err := opts.String("journalctl", "journalctl")
+	if err != nil {
+		return "", err
+	}
+	return exec.LookPath(journalctl)
+}
@@ -0,0 +1,213 @@
+package journald
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+// journal fields which are turned into tags, and the prefix they get
+var fieldTags = []struct {
+	field  string
+	prefix string
+}{
+	{"PRIORITY", "priority"},
+	{"SYSLOG_FACILITY", "facility"},
+	{"SYSLOG_IDENTIFIER", "tag"},
+	{"_SYSTEMD_UNIT", "unit"},
+	{"_SYSTEMD_USER_UNIT", "user_unit"},
+	{"_PID", "pid"},
+	{"_COMM", "comm"},
+	{"_EXE", "exe"},
+	{"_UID", "uid"},
+	{"MESSAGE_ID", "message_id"},
+	{"_TRANSPORT", "transport"},
+}
+
+type JournaldReporter struct {
+	host types.Host
+
+	journalctl string
+	matches    []string
+	cursorPath string
+
+	cursorMu    sync.Mutex
+	cursor      string
+	cursorDirty bool
+}
+
+func (*JournaldReporter) Id() string {
+	return "journald"
+}
+
+func (*JournaldReporter) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (*JournaldReporter) Metrics() ([]types.Metric, error) {
+	return []types.Metric{}, nil
+}
+
+func (*JournaldReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (jr *JournaldReporter) loadCursor() error {
+	b, err := ioutil.ReadFile(jr.cursorPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	jr.cursor = strings.TrimSpace(string(b))
+	return nil
+}
+
+func (jr *JournaldReporter) getCursor() string {
+	jr.cursorMu.Lock()
+	defer jr.cursorMu.Unlock()
+	return jr.cursor
+}
+
+func (jr *JournaldReporter) setCursor(cursor string) {
+	jr.cursorMu.Lock()
+	defer jr.cursorMu.Unlock()
+	jr.cursor = cursor
+	jr.cursorDirty = true
+}
+
+// saveCursor writes the cursor to disk if it has changed, so that we carry on
+// where we left off after a restart.
+func (jr *JournaldReporter) saveCursor() error {
+	jr.cursorMu.Lock()
+	cursor, dirty := jr.cursor, jr.cursorDirty
+	jr.cursorDirty = false
+	jr.cursorMu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	if err := writeCursor(jr.cursorPath, cursor); err != nil {
+		// try again next time
+		jr.cursorMu.Lock()
+		jr.cursorDirty = true
+		jr.cursorMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func writeCursor(cursorPath, cursor string) error {
+	f, err := ioutil.TempFile(filepath.Dir(cursorPath), "."+filepath.Base(cursorPath)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(cursor + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), cursorPath)
+}
+
+func (jr *JournaldReporter) entryToLogLine(e Entry) types.ReporterLogLine {
+	ts := time.Now()
+	if usec, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		ts = time.Unix(usec/1e6, (usec%1e6)*1e3)
+	}
+
+	tags := make([]string, 0, len(fieldTags))
+	for _, ft := range fieldTags {
+		if v, ok := e[ft.field]; ok && v != "" {
+			tags = append(tags, fmt.Sprintf("%s-%s", ft.prefix, v))
+		}
+	}
+
+	return types.ReporterLogLine{
+		Host:      jr.host,
+		Reporter:  jr,
+		LogLine:   e["MESSAGE"],
+		Tags:      tags,
+		Timestamp: ts,
+	}
+}
+
+// follow runs journalctl until it exits, sending each entry to s.
+func (jr *JournaldReporter) follow(s chan<- types.ReporterLogLine) error {
+	args := []string{"--output=export", "--follow", "--no-pager"}
+	if cursor := jr.getCursor(); cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		// first run: start from now rather than replaying the whole journal
+		args = append(args, "--lines=0")
+	}
+	args = append(args, jr.matches...)
+
+	cmd := exec.Command(jr.journalctl, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	er := NewExportReader(stdout)
+	for {
+		e, err := er.Next()
+		if err == io.EOF {
+			return fmt.Errorf("journalctl exited")
+		} else if err != nil {
+			return err
+		}
+
+		s <- jr.entryToLogLine(e)
+		if cursor, ok := e["__CURSOR"]; ok {
+			jr.setCursor(cursor)
+		}
+	}
+}
+
+func (jr *JournaldReporter) LogLines() <-chan types.ReporterLogLine {
+	s := make(chan types.ReporterLogLine)
+	go func() {
+		for {
+			if err := jr.follow(s); err != nil {
+				log.Println("journald:", err)
+			}
+			time.Sleep(5 * time.Second)
+		}
+	}()
+	go func() {
+		for range time.Tick(5 * time.Second) {
+			if err := jr.saveCursor(); err != nil {
+				log.Println("journald: failed to save cursor:", err)
+			}
+		}
+	}()
+	return s
+}
+
+// Close saves our position in the journal.
+func (jr *JournaldReporter) Close() error {
+	return jr.saveCursor()
+}
@@ -0,0 +1,82 @@
+package netstat
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(NetstatReporterFactory{})
+}
+
+type NetstatReporterFactory struct{}
+
+func (NetstatReporterFactory) Id() string {
+	return "netstat"
+}
+
+func (nrf NetstatReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := nrf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	nr := &NetstatReporter{
+		procNetPath: "/proc/net",
+		stop:        make(chan struct{}),
+	}
+
+	var err error
+
+	if nr.sampleInterval, err = opts.Duration("sample_interval", time.Minute); err != nil {
+		return nil, err
+	}
+	if nr.sampleInterval <= 0 {
+		return nil, fmt.Errorf("sample_interval must be positive")
+	}
+
+	// errors and drops are per minute, since any at all are worth knowing
+	// about
+	if nr.errorsThreshold, err = opts.Threshold("errors", types.Threshold{Warning: 1, Danger: 100}); err != nil {
+		return nil, err
+	}
+	if nr.dropsThreshold, err = opts.Threshold("drops", types.Threshold{Warning: 60, Danger: 6000}); err != nil {
+		return nil, err
+	}
+
+	ignoreInterfaces, err := opts.Strings("ignore_interfaces", []string{"lo"})
+	if err != nil {
+		return nil, err
+	}
+	nr.ignoreInterfaces = map[string]bool{}
+	for _, iface := range ignoreInterfaces {
+		nr.ignoreInterfaces[iface] = true
+	}
+
+	// take a first sample, so that we have rates after one sampleInterval
+	if err := nr.sample(); err != nil {
+		return nil, err
+	}
+	go nr.sampler()
+
+	return nr, nil
+}
+
+func (NetstatReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// is this a LinuxHost?
+	if !h.IsLocal() {
+		return false, nil
+	}
+
+	// can we see the network counters?
+	if _, err := os.Stat("/proc/net/dev"); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
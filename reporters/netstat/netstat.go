@@ -0,0 +1,308 @@
+package netstat
+
+import (
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+// NetstatReporter reports per-interface traffic and error rates, and TCP
+// connection counts, overall and by state.
+type NetstatReporter struct {
+	procNetPath string
+
+	errorsThreshold types.Threshold
+	dropsThreshold  types.Threshold
+
+	ignoreInterfaces map[string]bool
+
+	// rates are measured over the window between the last two samples,
+	// which we take every sampleInterval regardless of how often Metrics is
+	// called
+	sampleInterval time.Duration
+	stop           chan struct{}
+
+	mu         sync.Mutex
+	prevSample *sample
+	lastSample *sample
+}
+
+type sample struct {
+	at     time.Time
+	ifaces map[string]ifaceCounters
+	snmp   map[string]int64
+}
+
+func (*NetstatReporter) Id() string {
+	return "netstat"
+}
+
+func (*NetstatReporter) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (*NetstatReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (*NetstatReporter) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+// sample reads the current counters, moving the window along by one sample.
+// If they can't be read, the window stays where it was.
+func (nr *NetstatReporter) sample() error {
+	ifaces, err := netDev(filepath.Join(nr.procNetPath, "dev"))
+	var counters map[string]int64
+	if err == nil {
+		if counters, err = snmp(filepath.Join(nr.procNetPath, "snmp")); err != nil {
+			log.Println("netstat: failed to read snmp counters:", err)
+			counters, err = map[string]int64{}, nil
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+
+	nr.prevSample, nr.lastSample = nr.lastSample, &sample{
+		at:     time.Now(),
+		ifaces: ifaces,
+		snmp:   counters,
+	}
+	return nil
+}
+
+func (nr *NetstatReporter) sampler() {
+	ticker := time.NewTicker(nr.sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-nr.stop:
+			return
+		case <-ticker.C:
+			if err := nr.sample(); err != nil {
+				log.Println("netstat: failed to sample, keeping the previous window:", err)
+			}
+		}
+	}
+}
+
+// Close stops sampling.
+func (nr *NetstatReporter) Close() error {
+	close(nr.stop)
+	return nil
+}
+
+// delta copes with counters going backwards, e.g. when an interface is
+// recreated.
+func delta(now, last uint64) uint64 {
+	if now < last {
+		return 0
+	}
+	return now - last
+}
+
+func rate(d uint64, interval, per time.Duration) int {
+	if interval <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(d) * float64(per) / float64(interval)))
+}
+
+func (nr *NetstatReporter) interfaceMetrics(metrics []types.Metric, last, now *sample) []types.Metric {
+	interval := now.at.Sub(last.at)
+
+	names := make([]string, 0, len(now.ifaces))
+	for name := range now.ifaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if nr.ignoreInterfaces[name] {
+			continue
+		}
+		c := now.ifaces[name]
+		l, ok := last.ifaces[name]
+		if !ok {
+			// new since last time
+			continue
+		}
+
+		for _, dir := range []struct {
+			id, name                          string
+			bytes, packets, errors, drops     uint64
+			lbytes, lpackets, lerrors, ldrops uint64
+		}{
+			{"rx", "received", c.rxBytes, c.rxPackets, c.rxErrors, c.rxDrops, l.rxBytes, l.rxPackets, l.rxErrors, l.rxDrops},
+			{"tx", "transmitted", c.txBytes, c.txPackets, c.txErrors, c.txDrops, l.txBytes, l.txPackets, l.txErrors, l.txDrops},
+		} {
+			metrics = append(metrics,
+				types.NewGaugeMetric(
+					dir.id+"_bytes:"+name,
+					"Bytes "+dir.name+" on "+name,
+					"The number of bytes "+dir.name+" per second on "+name+" in the last sampling interval.",
+					rate(delta(dir.bytes, dir.lbytes), interval, time.Second),
+				),
+				types.NewGaugeMetric(
+					dir.id+"_packets:"+name,
+					"Packets "+dir.name+" on "+name,
+					"The number of packets "+dir.name+" per second on "+name+" in the last sampling interval.",
+					rate(delta(dir.packets, dir.lpackets), interval, time.Second),
+				),
+				types.NewThresholdMetric(
+					dir.id+"_errors:"+name,
+					"Errors on "+dir.name+" packets on "+name,
+					"The number of errors per minute on packets "+dir.name+" on "+name+" in the last sampling interval.",
+					rate(delta(dir.errors, dir.lerrors), interval, time.Minute),
+					&nr.errorsThreshold,
+				),
+				types.NewThresholdMetric(
+					dir.id+"_drops:"+name,
+					"Dropped "+dir.name+" packets on "+name,
+					"The number of packets "+dir.name+" on "+name+" dropped per minute in the last sampling interval.",
+					rate(delta(dir.drops, dir.ldrops), interval, time.Minute),
+					&nr.dropsThreshold,
+				),
+			)
+		}
+	}
+	return metrics
+}
+
+func (nr *NetstatReporter) tcpMetrics(metrics []types.Metric, last, now *sample) []types.Metric {
+	interval := now.at.Sub(last.at)
+
+	if v, ok := now.snmp["Tcp.CurrEstab"]; ok {
+		metrics = append(metrics, types.NewGaugeMetric(
+			"tcp_established",
+			"Established TCP connections",
+			"The number of TCP connections currently established or closing.",
+			int(v),
+		))
+	}
+
+	counters := []struct {
+		key, id, humanName, humanDesc string
+	}{
+		{"Tcp.ActiveOpens", "tcp_active_opens", "Outgoing TCP connections", "The number of outgoing TCP connections opened per minute in the last sampling interval."},
+		{"Tcp.PassiveOpens", "tcp_passive_opens", "Incoming TCP connections", "The number of incoming TCP connections accepted per minute in the last sampling interval."},
+		{"Tcp.AttemptFails", "tcp_attempt_fails", "Failed TCP connection attempts", "The number of failed TCP connection attempts per minute in the last sampling interval."},
+		{"Tcp.EstabResets", "tcp_estab_resets", "Reset TCP connections", "The number of established TCP connections reset per minute in the last sampling interval."},
+		{"Tcp.RetransSegs", "tcp_retrans_segs", "Retransmitted TCP segments", "The number of TCP segments retransmitted per minute in the last sampling interval."},
+		{"Tcp.InErrs", "tcp_in_errs", "TCP segments received in error", "The number of TCP segments received in error per minute in the last sampling interval."},
+	}
+	for _, c := range counters {
+		v, ok := now.snmp[c.key]
+		lv, lok := last.snmp[c.key]
+		if !ok || !lok {
+			continue
+		}
+		metrics = append(metrics, types.NewGaugeMetric(
+			c.id,
+			c.humanName,
+			c.humanDesc,
+			rate(delta(uint64(v), uint64(lv)), interval, time.Minute),
+		))
+	}
+
+	return metrics
+}
+
+func (nr *NetstatReporter) sockstatMetrics(metrics []types.Metric) []types.Metric {
+	counters, err := sockstat(filepath.Join(nr.procNetPath, "sockstat"))
+	if err != nil {
+		log.Println("netstat: failed to read sockstat:", err)
+		return metrics
+	}
+
+	gauges := []struct {
+		key, id, humanName, humanDesc string
+	}{
+		{"sockets.used", "sockets_used", "Sockets in use", "The number of sockets of any kind in use."},
+		{"TCP.inuse", "tcp_inuse", "TCP sockets in use", "The number of TCP sockets in use, including listening sockets."},
+		{"TCP.orphan", "tcp_orphan", "Orphaned TCP sockets", "The number of TCP sockets no longer attached to any process."},
+		{"TCP.tw", "tcp_timewait", "TCP sockets in TIME_WAIT", "The number of TCP sockets in the TIME_WAIT state."},
+		{"TCP.alloc", "tcp_alloc", "Allocated TCP sockets", "The number of TCP sockets allocated, including those in TIME_WAIT."},
+		{"UDP.inuse", "udp_inuse", "UDP sockets in use", "The number of UDP sockets in use."},
+	}
+	for _, g := range gauges {
+		if v, ok := counters[g.key]; ok {
+			metrics = append(metrics, types.NewGaugeMetric(
+				g.id,
+				g.humanName,
+				g.humanDesc,
+				int(v),
+			))
+		}
+	}
+	return metrics
+}
+
+// tcpStates are the socket states in /proc/net/tcp, in the kernel's order.
+var tcpStates = []struct {
+	id, name string
+}{
+	{"established", "ESTABLISHED"},
+	{"syn_sent", "SYN_SENT"},
+	{"syn_recv", "SYN_RECV"},
+	{"fin_wait1", "FIN_WAIT1"},
+	{"fin_wait2", "FIN_WAIT2"},
+	{"time_wait", "TIME_WAIT"},
+	{"close", "CLOSE"},
+	{"close_wait", "CLOSE_WAIT"},
+	{"last_ack", "LAST_ACK"},
+	{"listen", "LISTEN"},
+	{"closing", "CLOSING"},
+}
+
+func (nr *NetstatReporter) tcpStateMetrics(metrics []types.Metric) []types.Metric {
+	counts := map[int]int{}
+	for _, name := range []string{"tcp", "tcp6"} {
+		if err := tcpStateCounts(filepath.Join(nr.procNetPath, name), counts); err != nil {
+			if name == "tcp6" && os.IsNotExist(err) {
+				// IPv6 is disabled
+				continue
+			}
+			log.Println("netstat: failed to read", name, "sockets:", err)
+			return metrics
+		}
+	}
+
+	for n, st := range tcpStates {
+		metrics = append(metrics, types.NewGaugeMetric(
+			"tcp_state_"+st.id,
+			"TCP sockets in "+st.name,
+			"The number of TCP sockets, over IPv4 and IPv6, in the "+st.name+" state.",
+			counts[n+1],
+		))
+	}
+	return metrics
+}
+
+func (nr *NetstatReporter) Metrics() ([]types.Metric, error) {
+	nr.mu.Lock()
+	last, now := nr.prevSample, nr.lastSample
+	nr.mu.Unlock()
+
+	// rates need a whole window, so there are none until the second sample
+	metrics := []types.Metric{}
+	if last != nil {
+		metrics = nr.interfaceMetrics(metrics, last, now)
+		metrics = nr.tcpMetrics(metrics, last, now)
+	}
+	metrics = nr.sockstatMetrics(metrics)
+	metrics = nr.tcpStateMetrics(metrics)
+
+	return metrics, nil
+}
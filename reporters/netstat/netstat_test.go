@@ -0,0 +1,94 @@
+package netstat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/icphalanx/agent/types"
+)
+
+const testNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0: 1000 10 0 0 0 0 0 0 2000 20 0 0 0 0 0 0
+`
+
+const testTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0 100 0 0 10 0
+   1: 0100007F:0277 0100007F:A000 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0 100 0 0 10 0
+   2: 0100007F:A002 0100007F:0050 02 00000000:00000000 00:00000000 00000000     0        0 3 1 0 100 0 0 10 0
+`
+
+const testTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0277 00000000000000000000000001000000:A001 08 00000000:00000000 00:00000000 00000000     0        0 4 1 0 100 0 0 10 0
+`
+
+func writeNet(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func metricsById(t *testing.T, nr *NetstatReporter) map[string]int {
+	t.Helper()
+	metrics, err := nr.Metrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byId := map[string]int{}
+	for _, m := range metrics {
+		byId[m.Id()] = m.(types.MetricUncountable).Value()
+	}
+	return byId
+}
+
+func TestTCPStates(t *testing.T) {
+	dir := t.TempDir()
+	nr := &NetstatReporter{procNetPath: dir}
+	writeNet(t, dir, "tcp", testTCP)
+	writeNet(t, dir, "tcp6", testTCP6)
+
+	byId := metricsById(t, nr)
+	for id, want := range map[string]int{
+		"tcp_state_listen":      1,
+		"tcp_state_established": 1,
+		"tcp_state_syn_sent":    1,
+		"tcp_state_close_wait":  1,
+		"tcp_state_time_wait":   0,
+	} {
+		if got, ok := byId[id]; !ok || got != want {
+			t.Errorf("%s is %d (reported: %v), want %d", id, got, ok, want)
+		}
+	}
+
+	// without IPv6
+	os.Remove(filepath.Join(dir, "tcp6"))
+	if got := metricsById(t, nr)["tcp_state_close_wait"]; got != 0 {
+		t.Errorf("tcp_state_close_wait is %d without tcp6, want 0", got)
+	}
+}
+
+func TestFailedSampleKeepsWindow(t *testing.T) {
+	dir := t.TempDir()
+	nr := &NetstatReporter{procNetPath: dir}
+	writeNet(t, dir, "dev", testNetDev)
+
+	for i := 0; i < 2; i++ {
+		if err := nr.sample(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := metricsById(t, nr)["rx_bytes:eth0"]; !ok {
+		t.Fatal("no interface metrics after two samples")
+	}
+
+	os.Remove(filepath.Join(dir, "dev"))
+	if err := nr.sample(); err == nil {
+		t.Fatal("sample succeeded without net/dev")
+	}
+	if _, ok := metricsById(t, nr)["rx_bytes:eth0"]; !ok {
+		t.Error("interface metrics were dropped after one failed sample")
+	}
+}
@@ -0,0 +1,165 @@
+package netstat
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ifaceCounters are the counters for one interface from /proc/net/dev.
+type ifaceCounters struct {
+	rxBytes, rxPackets, rxErrors, rxDrops uint64
+	txBytes, txPackets, txErrors, txDrops uint64
+}
+
+// netDev reads /proc/net/dev, e.g.
+//
+//	Inter-|   Receive                                                |  Transmit
+//	 face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+//	  eth0: 1234 5 0 0 0 0 0 0 5678 9 0 0 0 0 0 0
+func netDev(path string) (map[string]ifaceCounters, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ifaces := map[string]ifaceCounters{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			// a header
+			continue
+		}
+
+		name := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("malformed net/dev line %q", line)
+		}
+
+		vals := make([]uint64, 16)
+		for n := range vals {
+			if vals[n], err = strconv.ParseUint(fields[n], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		ifaces[name] = ifaceCounters{
+			rxBytes: vals[0], rxPackets: vals[1], rxErrors: vals[2], rxDrops: vals[3],
+			txBytes: vals[8], txPackets: vals[9], txErrors: vals[10], txDrops: vals[11],
+		}
+	}
+	return ifaces, sc.Err()
+}
+
+// snmp reads /proc/net/snmp, which is pairs of lines naming then giving the
+// values for each protocol's counters, e.g.
+//
+//	Tcp: RtoAlgorithm RtoMin ... CurrEstab ...
+//	Tcp: 1 200 ... 12 ...
+//
+// The result is keyed by e.g. "Tcp.CurrEstab".
+func snmp(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := map[string]int64{}
+	var header []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		if header == nil || header[0] != fields[0] {
+			header = fields
+			continue
+		}
+
+		proto := strings.TrimSuffix(fields[0], ":")
+		for n := 1; n < len(fields) && n < len(header); n++ {
+			// some counters (e.g. Tcp.MaxConn) can be negative
+			v, err := strconv.ParseInt(fields[n], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			counters[proto+"."+header[n]] = v
+		}
+		header = nil
+	}
+	return counters, sc.Err()
+}
+
+// sockstat reads /proc/net/sockstat, e.g.
+//
+//	sockets: used 290
+//	TCP: inuse 5 orphan 0 tw 2 alloc 7 mem 1
+//
+// The result is keyed by e.g. "TCP.tw".
+func sockstat(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := map[string]int64{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		proto := strings.TrimSuffix(fields[0], ":")
+		for n := 1; n+1 < len(fields); n += 2 {
+			v, err := strconv.ParseInt(fields[n+1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			counters[proto+"."+fields[n]] = v
+		}
+	}
+	return counters, sc.Err()
+}
+
+// tcpStateCounts adds up the sockets in each state in /proc/net/tcp or
+// /proc/net/tcp6, e.g.
+//
+//	sl  local_address rem_address   st tx_queue rx_queue ...
+//	 0: 0100007F:0277 00000000:0000 0A 00000000:00000000 ...
+//
+// into counts, keyed by the kernel's state number ("st", in hex).
+func tcpStateCounts(path string, counts map[int]int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for first := true; sc.Scan(); first = false {
+		if first {
+			// the header
+			continue
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 {
+			return fmt.Errorf("malformed socket line %q", sc.Text())
+		}
+		st, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return err
+		}
+		counts[int(st)]++
+	}
+	return sc.Err()
+}
@@ -0,0 +1,179 @@
+package netsyslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(NetSyslogReporterFactory{})
+}
+
+type NetSyslogReporterFactory struct{}
+
+func (NetSyslogReporterFactory) Id() string {
+	return "netsyslog"
+}
+
+func loadTLSConfig(opts types.ReporterOptions) (*tls.Config, error) {
+	certPath, err := opts.String("tls_cert", "")
+	if err != nil {
+		return nil, err
+	}
+	keyPath, err := opts.String("tls_key", "")
+	if err != nil {
+		return nil, err
+	}
+	if certPath == "" || keyPath == "" {
+		return nil, fmt.Errorf("tls_cert and tls_key must be set to listen with TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	// if given, senders must present a certificate signed by this CA
+	clientCAPath, err := opts.String("tls_client_ca", "")
+	if err != nil {
+		return nil, err
+	}
+	if clientCAPath != "" {
+		b, err := ioutil.ReadFile(clientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("failed to load certificate from PEM")
+		}
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return c, nil
+}
+
+func (nsrf NetSyslogReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := nsrf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	udpAddr, tcpAddr, tlsAddr, err := listenAddrs(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	maxMessageSize, err := opts.Int("max_message_size", 65536)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSenders, err := opts.Int("max_senders", 1024)
+	if err != nil {
+		return nil, err
+	}
+	if maxSenders < 1 {
+		return nil, fmt.Errorf("max_senders must be positive")
+	}
+
+	readTimeout, err := opts.Duration("read_timeout", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if readTimeout <= 0 {
+		return nil, fmt.Errorf("read_timeout must be positive")
+	}
+
+	maxConnections, err := opts.Int("max_connections", 256)
+	if err != nil {
+		return nil, err
+	}
+	if maxConnections < 1 {
+		return nil, fmt.Errorf("max_connections must be positive")
+	}
+
+	nsr := &NetSyslogReporter{
+		host:           h,
+		maxMessageSize: maxMessageSize,
+		readTimeout:    readTimeout,
+		connSlots:      make(chan struct{}, maxConnections),
+		maxSenders:     maxSenders,
+		senders:        map[string]*SenderHost{},
+	}
+
+	if udpAddr != "" {
+		ua, err := net.ResolveUDPAddr("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		if nsr.udpConn, err = net.ListenUDP("udp", ua); err != nil {
+			return nil, err
+		}
+	}
+
+	if tcpAddr != "" {
+		ln, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			nsr.Close()
+			return nil, err
+		}
+		nsr.streamListeners = append(nsr.streamListeners, ln)
+	}
+
+	if tlsAddr != "" {
+		tlsConfig, err := loadTLSConfig(opts)
+		if err != nil {
+			nsr.Close()
+			return nil, err
+		}
+		ln, err := tls.Listen("tcp", tlsAddr, tlsConfig)
+		if err != nil {
+			nsr.Close()
+			return nil, err
+		}
+		nsr.streamListeners = append(nsr.streamListeners, ln)
+	}
+
+	return nsr, nil
+}
+
+// listenAddrs returns the addresses we've been configured to listen on for
+// each transport, any of which may be empty.
+func listenAddrs(opts types.ReporterOptions) (udpAddr, tcpAddr, tlsAddr string, err error) {
+	if udpAddr, err = opts.String("udp", ""); err != nil {
+		return "", "", "", err
+	}
+	if tcpAddr, err = opts.String("tcp", ""); err != nil {
+		return "", "", "", err
+	}
+	if tlsAddr, err = opts.String("tls", ""); err != nil {
+		return "", "", "", err
+	}
+	return udpAddr, tcpAddr, tlsAddr, nil
+}
+
+func (NetSyslogReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// we can only listen on our own interfaces
+	if !h.IsLocal() {
+		return false, nil
+	}
+
+	// and only if we've been told where to listen
+	udpAddr, tcpAddr, tlsAddr, err := listenAddrs(opts)
+	if err != nil {
+		return false, err
+	}
+	return udpAddr != "" || tcpAddr != "" || tlsAddr != "", nil
+}
@@ -0,0 +1,246 @@
+package netsyslog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/reporters/syslogmsg"
+	"github.com/icphalanx/agent/types"
+)
+
+// NetSyslogReporter receives syslog from other devices over UDP, TCP and
+// TLS (RFC 5425), turning each sender into a child host.
+type NetSyslogReporter struct {
+	host types.Host
+
+	udpConn         *net.UDPConn
+	streamListeners []net.Listener
+	maxMessageSize  int
+
+	// stream connections are dropped if they send nothing for this long
+	readTimeout time.Duration
+	// connection slots for stream senders; once they're all taken, new
+	// connections are turned away
+	connSlots chan struct{}
+
+	// once we've heard from this many devices, we forget the one we heard
+	// from least recently to make room
+	maxSenders int
+
+	sendersMu sync.Mutex
+	senders   map[string]*SenderHost
+}
+
+func (*NetSyslogReporter) Id() string {
+	return "netsyslog"
+}
+
+func (*NetSyslogReporter) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (*NetSyslogReporter) Metrics() ([]types.Metric, error) {
+	return []types.Metric{}, nil
+}
+
+// Hosts returns every device we've heard from.
+func (nsr *NetSyslogReporter) Hosts() ([]types.Host, error) {
+	nsr.sendersMu.Lock()
+	defer nsr.sendersMu.Unlock()
+
+	addrs := make([]string, 0, len(nsr.senders))
+	for addr := range nsr.senders {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	hosts := make([]types.Host, len(addrs))
+	for n, addr := range addrs {
+		hosts[n] = nsr.senders[addr]
+	}
+	return hosts, nil
+}
+
+// sender returns the host for the device at addr, creating it if this is
+// the first we've heard from it.
+func (nsr *NetSyslogReporter) sender(addr net.Addr) *SenderHost {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	nsr.sendersMu.Lock()
+	defer nsr.sendersMu.Unlock()
+
+	sh, ok := nsr.senders[ip]
+	if !ok {
+		if len(nsr.senders) >= nsr.maxSenders {
+			nsr.forgetOldestSender()
+		}
+		log.Println("netsyslog: new sender", ip)
+		sh = &SenderHost{parent: nsr.host, addr: ip}
+		nsr.senders[ip] = sh
+	}
+	sh.heardFrom()
+	return sh
+}
+
+// forgetOldestSender removes the sender we heard from least recently. It must
+// be called with sendersMu held.
+func (nsr *NetSyslogReporter) forgetOldestSender() {
+	var (
+		oldest     string
+		oldestSeen time.Time
+	)
+	for ip, sh := range nsr.senders {
+		if seen := sh.LastSeen(); oldest == "" || seen.Before(oldestSeen) {
+			oldest, oldestSeen = ip, seen
+		}
+	}
+
+	log.Println("netsyslog: too many senders, forgetting", oldest)
+	delete(nsr.senders, oldest)
+}
+
+func (nsr *NetSyslogReporter) logLine(addr net.Addr, b []byte) types.ReporterLogLine {
+	msg, err := syslogmsg.Parse(b)
+	if err != nil {
+		log.Println("netsyslog: failed to parse message from", addr, err)
+		msg = syslogmsg.Raw(b)
+	}
+
+	tags := msg.Tags
+	if msg.Hostname != "" {
+		tags = append(tags, "hostname-"+msg.Hostname)
+	}
+
+	return types.ReporterLogLine{
+		Host:      nsr.sender(addr),
+		Reporter:  nsr,
+		LogLine:   msg.Content,
+		Tags:      tags,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+func (nsr *NetSyslogReporter) serveUDP(s chan<- types.ReporterLogLine) {
+	buf := make([]byte, nsr.maxMessageSize)
+	for {
+		n, addr, err := nsr.udpConn.ReadFrom(buf)
+		if err != nil {
+			log.Println("netsyslog: udp: stopping:", err)
+			return
+		}
+		s <- nsr.logLine(addr, buf[:n])
+	}
+}
+
+// readFrame reads a single message from a stream, which may be framed either
+// by octet counting ("LEN SP MSG") or by a trailing newline (RFC 6587).
+func readFrame(br *bufio.Reader, maxMessageSize int) ([]byte, error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// a syslog message itself always starts with '<'
+	if first[0] >= '0' && first[0] <= '9' {
+		lenBytes, err := br.ReadSlice(' ')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(string(bytes.TrimSuffix(lenBytes, []byte(" "))))
+		if err != nil {
+			return nil, fmt.Errorf("bad octet count %q", lenBytes)
+		}
+		if n > maxMessageSize {
+			return nil, fmt.Errorf("message of %d bytes is too large", n)
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(br, b)
+		return b, err
+	}
+
+	b, err := br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("message is too large")
+	} else if err != nil && !(err == io.EOF && len(b) > 0) {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+func (nsr *NetSyslogReporter) serveConn(conn net.Conn, s chan<- types.ReporterLogLine) {
+	defer conn.Close()
+	defer func() { <-nsr.connSlots }()
+
+	br := bufio.NewReaderSize(conn, nsr.maxMessageSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(nsr.readTimeout)); err != nil {
+			log.Println("netsyslog: dropping connection from", conn.RemoteAddr(), err)
+			return
+		}
+		b, err := readFrame(br, nsr.maxMessageSize)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Println("netsyslog: dropping connection from", conn.RemoteAddr(), err)
+			return
+		}
+		if len(b) == 0 {
+			continue
+		}
+		s <- nsr.logLine(conn.RemoteAddr(), b)
+	}
+}
+
+func (nsr *NetSyslogReporter) serveStream(ln net.Listener, s chan<- types.ReporterLogLine) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Println("netsyslog:", ln.Addr(), "stopping:", err)
+			return
+		}
+
+		select {
+		case nsr.connSlots <- struct{}{}:
+			go nsr.serveConn(conn, s)
+		default:
+			log.Println("netsyslog: too many connections, turning away", conn.RemoteAddr())
+			conn.Close()
+		}
+	}
+}
+
+func (nsr *NetSyslogReporter) LogLines() <-chan types.ReporterLogLine {
+	s := make(chan types.ReporterLogLine)
+	if nsr.udpConn != nil {
+		go nsr.serveUDP(s)
+	}
+	for _, ln := range nsr.streamListeners {
+		go nsr.serveStream(ln, s)
+	}
+	return s
+}
+
+// Close stops all of our listeners.
+func (nsr *NetSyslogReporter) Close() error {
+	var err error
+	if nsr.udpConn != nil {
+		err = nsr.udpConn.Close()
+	}
+	for _, ln := range nsr.streamListeners {
+		if lerr := ln.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package netsyslog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+// SenderHost is a device which has sent us syslog over the network. It is
+// known by its address, since anything can claim any hostname in its
+// messages; the claimed hostname is tagged on each log line instead.
+type SenderHost struct {
+	parent types.Host
+
+	addr string
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+// heardFrom records that the device has just sent us a message.
+func (sh *SenderHost) heardFrom() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.lastSeen = time.Now()
+}
+
+// LastSeen is when the device last sent us a message.
+func (sh *SenderHost) LastSeen() time.Time {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.lastSeen
+}
+
+func (sh *SenderHost) Id() string {
+	return "netsyslog-" + sh.addr
+}
+
+func (*SenderHost) IsLocal() bool {
+	return false
+}
+
+// HumanName is the address the device sent from.
+func (sh *SenderHost) HumanName() (string, error) {
+	return sh.addr, nil
+}
+
+func (sh *SenderHost) Parent() (types.Host, error) {
+	return sh.parent, nil
+}
+
+func (*SenderHost) Reporters() ([]types.Reporter, error) {
+	return []types.Reporter{}, nil
+}
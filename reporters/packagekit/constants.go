@@ -31,3 +31,73 @@ const (
 	PK_FILTER_ENUM_APPLICATION
 	PK_FILTER_ENUM_NOT_APPLICATION
 )
+
+// PackageKitInfoEnum is the info argument of the Package signal. Since
+// PackageKit 1.2.4, updates may also carry a severity (itself one of these
+// values) in the upper 16 bits.
+type PackageKitInfoEnum uint32
+
+const (
+	PK_INFO_ENUM_UNKNOWN = iota
+	PK_INFO_ENUM_INSTALLED
+	PK_INFO_ENUM_AVAILABLE
+	PK_INFO_ENUM_LOW
+	PK_INFO_ENUM_ENHANCEMENT
+	PK_INFO_ENUM_NORMAL
+	PK_INFO_ENUM_BUGFIX
+	PK_INFO_ENUM_IMPORTANT
+	PK_INFO_ENUM_SECURITY
+	PK_INFO_ENUM_BLOCKED
+	PK_INFO_ENUM_DOWNLOADING
+	PK_INFO_ENUM_UPDATING
+	PK_INFO_ENUM_INSTALLING
+	PK_INFO_ENUM_REMOVING
+	PK_INFO_ENUM_CLEANUP
+	PK_INFO_ENUM_OBSOLETING
+	PK_INFO_ENUM_COLLECTION_INSTALLED
+	PK_INFO_ENUM_COLLECTION_AVAILABLE
+	PK_INFO_ENUM_FINISHED
+	PK_INFO_ENUM_REINSTALLING
+	PK_INFO_ENUM_DOWNGRADING
+	PK_INFO_ENUM_PREPARING
+	PK_INFO_ENUM_DECOMPRESSING
+	PK_INFO_ENUM_UNTRUSTED
+	PK_INFO_ENUM_TRUSTED
+	PK_INFO_ENUM_UNAVAILABLE
+	PK_INFO_ENUM_CRITICAL
+)
+
+func (ie PackageKitInfoEnum) Kind() PackageKitInfoEnum {
+	return ie & 0xffff
+}
+
+func (ie PackageKitInfoEnum) Severity() PackageKitInfoEnum {
+	return ie >> 16
+}
+
+// PackageKitRestartEnum is what an update needs restarting once installed.
+type PackageKitRestartEnum uint32
+
+const (
+	PK_RESTART_ENUM_UNKNOWN = iota
+	PK_RESTART_ENUM_NONE
+	PK_RESTART_ENUM_APPLICATION
+	PK_RESTART_ENUM_SESSION
+	PK_RESTART_ENUM_SYSTEM
+	PK_RESTART_ENUM_SECURITY_SESSION
+	PK_RESTART_ENUM_SECURITY_SYSTEM
+)
+
+func (re PackageKitRestartEnum) String() string {
+	switch re {
+	case PK_RESTART_ENUM_NONE:
+		return "none"
+	case PK_RESTART_ENUM_APPLICATION:
+		return "application"
+	case PK_RESTART_ENUM_SESSION, PK_RESTART_ENUM_SECURITY_SESSION:
+		return "session"
+	case PK_RESTART_ENUM_SYSTEM, PK_RESTART_ENUM_SECURITY_SYSTEM:
+		return "system"
+	}
+	return "unknown"
+}
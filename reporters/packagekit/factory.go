@@ -16,9 +16,23 @@ func (PackageKitReporterFactory) Id() string {
 	return "packagekit"
 }
 
-func (pkrf PackageKitReporterFactory) Create(h types.Host) (types.Reporter, error) {
-	if at, err := pkrf.ApplicableTo(h); !at {
+func (pkrf PackageKitReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := pkrf.ApplicableTo(h, opts); err != nil {
 		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	needUpdateThreshold, err := opts.Threshold("needupdate", types.Threshold{Warning: 1, Danger: 21})
+	if err != nil {
+		return nil, err
+	}
+
+	categoryThresholds := map[PackageKitInfoEnum]types.Threshold{}
+	for _, uc := range updateCategories {
+		if categoryThresholds[uc.info], err = opts.Threshold("needupdate_"+uc.id, uc.defaultThreshold); err != nil {
+			return nil, err
+		}
 	}
 
 	dbusConn, err := dbus.SystemBus()
@@ -26,13 +40,18 @@ func (pkrf PackageKitReporterFactory) Create(h types.Host) (types.Reporter, erro
 		return nil, err
 	}
 
-	return PackageKitReporter{
+	return &PackageKitReporter{
 		dbusConn: dbusConn,
 		dbusObj:  dbusConn.Object("org.freedesktop.PackageKit", "/org/freedesktop/PackageKit"),
+
+		needUpdateThreshold: needUpdateThreshold,
+		categoryThresholds:  categoryThresholds,
+
+		issues: types.NewIssueTracker(h),
 	}, nil
 }
 
-func (PackageKitReporterFactory) ApplicableTo(h types.Host) (bool, error) {
+func (PackageKitReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
 	// is this a LinuxHost?
 	if !h.IsLocal() {
 		return false, nil
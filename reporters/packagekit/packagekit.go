@@ -4,22 +4,225 @@ import (
 	"fmt"
 	"github.com/godbus/dbus"
 	"github.com/icphalanx/agent/types"
+	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
+// GetUpdates and GetUpdateDetail are slow, so Issues and Metrics share their
+// results for this long, which covers both within a single report.
+const updatesMaxAge = 30 * time.Second
+
 type PackageKitReporter struct {
 	dbusConn *dbus.Conn
 	dbusObj  dbus.BusObject
+
+	needUpdateThreshold types.Threshold
+
+	// warning and danger levels for each of updateCategories
+	categoryThresholds map[PackageKitInfoEnum]types.Threshold
+
+	issues *types.IssueTracker
+
+	mu        sync.Mutex
+	updates   []update
+	updatesAt time.Time
+	// details of updates, or nil if we haven't fetched them yet
+	details []updateDetail
+}
+
+// updateCategories are the kinds of update we break needupdate down by.
+var updateCategories = []struct {
+	info      PackageKitInfoEnum
+	id        string
+	humanName string
+	humanDesc string
+
+	defaultThreshold types.Threshold
+}{
+	{PK_INFO_ENUM_SECURITY, "security", "Packages requiring security updates", "The number of packages for which updates fixing security issues are available.", types.Threshold{Warning: 1, Danger: 5}},
+	{PK_INFO_ENUM_IMPORTANT, "important", "Packages requiring important updates", "The number of packages for which updates marked as important are available.", types.Threshold{Warning: 1, Danger: 10}},
+	{PK_INFO_ENUM_BUGFIX, "bugfix", "Packages requiring bug fix updates", "The number of packages for which updates fixing bugs are available.", types.Threshold{Warning: 10, Danger: 50}},
+	{PK_INFO_ENUM_ENHANCEMENT, "enhancement", "Packages with enhancement updates", "The number of packages for which updates adding new features are available.", types.Threshold{Warning: 50, Danger: 200}},
+}
+
+// update is a Package signal emitted by GetUpdates.
+type update struct {
+	info      PackageKitInfoEnum
+	packageID string
+	summary   string
+}
+
+// category works out which of updateCategories an update belongs to.
+func (u update) category() PackageKitInfoEnum {
+	switch kind := u.info.Kind(); kind {
+	case PK_INFO_ENUM_SECURITY, PK_INFO_ENUM_IMPORTANT, PK_INFO_ENUM_BUGFIX, PK_INFO_ENUM_ENHANCEMENT:
+		return kind
+	}
+
+	// otherwise go by how severe the distribution says it is
+	switch u.info.Severity() {
+	case PK_INFO_ENUM_IMPORTANT, PK_INFO_ENUM_CRITICAL:
+		return PK_INFO_ENUM_IMPORTANT
+	}
+	return u.info.Kind()
 }
 
-func (PackageKitReporter) Id() string {
+func (*PackageKitReporter) Id() string {
 	return "packagekit"
 }
 
-func (PackageKitReporter) Issues() ([]types.Issue, error) {
-	return []types.Issue{}, nil
+// updateGroup is the updates covered by a single advisory, or a single
+// update with no advisory.
+type updateGroup struct {
+	id         string
+	advisories []string
+	packageIDs []string
+	cves       []string
+	restart    PackageKitRestartEnum
+	issued     string
+	summary    string
+
+	security bool
+	critical bool
 }
 
-func (pkr PackageKitReporter) createTransaction(cb func(dbus.BusObject, <-chan *dbus.Signal) error) error {
+// restartRank orders restart requirements by how disruptive they are.
+func restartRank(re PackageKitRestartEnum) int {
+	switch re.String() {
+	case "system":
+		return 3
+	case "session":
+		return 2
+	case "application":
+		return 1
+	}
+	return 0
+}
+
+func appendNew(ss []string, more ...string) []string {
+	for _, m := range more {
+		found := false
+		for _, s := range ss {
+			found = found || s == m
+		}
+		if !found {
+			ss = append(ss, m)
+		}
+	}
+	return ss
+}
+
+func (pkr *PackageKitReporter) Issues() ([]types.Issue, error) {
+	// don't let PackageKit being slow or broken lose the rest of the report
+	updates, details, err := pkr.listUpdateDetails()
+	if err != nil {
+		log.Println("packagekit: failed to list updates:", err)
+		return pkr.issues.Last(), nil
+	}
+
+	byID := map[string]update{}
+	for _, u := range updates {
+		byID[u.packageID] = u
+	}
+
+	// group updates by advisory, so that one advisory covering several
+	// packages is one issue, and the collector can match them up across
+	// hosts
+	groups := map[string]*updateGroup{}
+	order := []string{}
+	for _, ud := range details {
+		advisories, cves := ud.advisories(), ud.cves()
+		if len(advisories) == 0 && len(cves) == 0 && restartRank(ud.restart) < restartRank(PK_RESTART_ENUM_SESSION) {
+			// nothing to say beyond what needupdate already tells us
+			continue
+		}
+
+		id := "update:" + packageName(ud.packageID)
+		if len(advisories) > 0 {
+			id = "advisory:" + advisories[0]
+		}
+		g, ok := groups[id]
+		if !ok {
+			g = &updateGroup{id: id, issued: ud.issued, summary: ud.changelogSummary()}
+			groups[id] = g
+			order = append(order, id)
+		}
+
+		g.advisories = appendNew(g.advisories, advisories...)
+		g.packageIDs = appendNew(g.packageIDs, ud.packageID)
+		g.cves = appendNew(g.cves, cves...)
+		if restartRank(ud.restart) > restartRank(g.restart) {
+			g.restart = ud.restart
+		}
+
+		u := byID[ud.packageID]
+		if u.category() == PK_INFO_ENUM_SECURITY {
+			g.security = true
+			g.critical = g.critical || u.info.Severity() == PK_INFO_ENUM_CRITICAL
+		}
+	}
+
+	issues := []types.IssueDetails{}
+	for _, id := range order {
+		g := groups[id]
+
+		names := make([]string, len(g.packageIDs))
+		for n, packageID := range g.packageIDs {
+			names[n] = packageName(packageID)
+		}
+		names = appendNew(nil, names...)
+
+		title := "Update available for " + strings.Join(names, ", ")
+		if len(g.advisories) > 0 {
+			title = fmt.Sprintf("%s affects %s", strings.Join(g.advisories, ", "), strings.Join(names, ", "))
+		}
+
+		desc := []string{"Updates are available for " + strings.Join(g.packageIDs, ", ") + "."}
+		if len(g.advisories) > 0 {
+			desc = append(desc, "Advisories: "+strings.Join(g.advisories, ", ")+".")
+		}
+		if g.issued != "" {
+			desc = append(desc, "Issued "+g.issued+".")
+		}
+		if len(g.cves) > 0 {
+			desc = append(desc, "CVEs: "+strings.Join(g.cves, " ")+".")
+		}
+		if restartRank(g.restart) > 0 {
+			desc = append(desc, "Requires a "+g.restart.String()+" restart once installed.")
+		}
+		if g.summary != "" {
+			desc = append(desc, "Changelog: "+g.summary)
+		}
+
+		remediation := "Install the update with `pkcon update " + strings.Join(names, " ") + "`"
+		if restartRank(g.restart) > 0 {
+			remediation += ", then restart the " + g.restart.String()
+		}
+		remediation += "."
+
+		var severity types.IssueSeverity = types.ISSUESEVERITY_INFO
+		switch {
+		case g.critical:
+			severity = types.ISSUESEVERITY_CRITICAL
+		case g.security || g.restart.String() == "system":
+			severity = types.ISSUESEVERITY_WARNING
+		}
+
+		issues = append(issues, types.IssueDetails{
+			Id:          g.id,
+			Severity:    severity,
+			Title:       title,
+			Description: strings.Join(desc, "\n"),
+			Remediation: remediation,
+		})
+	}
+
+	return pkr.issues.Issues(issues, time.Now()), nil
+}
+
+func (pkr *PackageKitReporter) createTransaction(cb func(dbus.BusObject, <-chan *dbus.Signal) error) error {
 	call := pkr.dbusObj.Call("org.freedesktop.PackageKit.CreateTransaction", 0)
 	if call.Err != nil {
 		return call.Err
@@ -47,7 +250,22 @@ func (pkr PackageKitReporter) createTransaction(cb func(dbus.BusObject, <-chan *
 	return cb(trans, ch)
 }
 
-func (pkr PackageKitReporter) countPackages(txCall string, filter PackageKitFilterBitField) (int, error) {
+// nextSignal waits for the next signal for the transaction at path, skipping
+// those from any other transactions running at the same time. It returns nil
+// if ch is closed.
+func nextSignal(path dbus.ObjectPath, ch <-chan *dbus.Signal) *dbus.Signal {
+	for {
+		s, ok := <-ch
+		if !ok {
+			return nil
+		}
+		if s.Path == path {
+			return s
+		}
+	}
+}
+
+func (pkr *PackageKitReporter) countPackages(txCall string, filter PackageKitFilterBitField) (int, error) {
 	count := 0
 	txCall = fmt.Sprintf("org.freedesktop.PackageKit.Transaction.%s", txCall)
 	err := pkr.createTransaction(func(trans dbus.BusObject, ch <-chan *dbus.Signal) error {
@@ -57,8 +275,8 @@ func (pkr PackageKitReporter) countPackages(txCall string, filter PackageKitFilt
 		}
 
 		for {
-			s := <-ch
-			if s.Name == "org.freedesktop.PackageKit.Transaction.Finished" || s.Name == "org.freedesktop.PackageKit.Transaction.Destroy" {
+			s := nextSignal(trans.Path(), ch)
+			if s == nil || s.Name == "org.freedesktop.PackageKit.Transaction.Finished" || s.Name == "org.freedesktop.PackageKit.Transaction.Destroy" {
 				break
 			}
 			if s.Name == "org.freedesktop.PackageKit.Transaction.Package" {
@@ -71,36 +289,123 @@ func (pkr PackageKitReporter) countPackages(txCall string, filter PackageKitFilt
 	return count, err
 }
 
-func (pkr PackageKitReporter) Metrics() ([]types.Metric, error) {
+// listUpdates returns the available updates, fetching them again only if
+// they're more than updatesMaxAge old.
+func (pkr *PackageKitReporter) listUpdates() ([]update, error) {
+	pkr.mu.Lock()
+	defer pkr.mu.Unlock()
+
+	return pkr.listUpdatesLocked()
+}
+
+func (pkr *PackageKitReporter) listUpdatesLocked() ([]update, error) {
+	if pkr.updates != nil && time.Since(pkr.updatesAt) < updatesMaxAge {
+		return pkr.updates, nil
+	}
+
+	updates := []update{}
+	err := pkr.createTransaction(func(trans dbus.BusObject, ch <-chan *dbus.Signal) error {
+		err := trans.Call("org.freedesktop.PackageKit.Transaction.GetUpdates", 0, uint64(0)).Err
+		if err != nil {
+			return err
+		}
+
+		for {
+			s := nextSignal(trans.Path(), ch)
+			if s == nil || s.Name == "org.freedesktop.PackageKit.Transaction.Finished" || s.Name == "org.freedesktop.PackageKit.Transaction.Destroy" {
+				break
+			}
+			if s.Name == "org.freedesktop.PackageKit.Transaction.Package" {
+				info, _ := s.Body[0].(uint32)
+				packageID, _ := s.Body[1].(string)
+				summary, _ := s.Body[2].(string)
+				updates = append(updates, update{PackageKitInfoEnum(info), packageID, summary})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pkr.updates, pkr.updatesAt, pkr.details = updates, time.Now(), nil
+	return updates, nil
+}
+
+// listUpdateDetails returns the available updates and their details, fetching
+// the details once each time we fetch the updates.
+func (pkr *PackageKitReporter) listUpdateDetails() ([]update, []updateDetail, error) {
+	pkr.mu.Lock()
+	defer pkr.mu.Unlock()
+
+	updates, err := pkr.listUpdatesLocked()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pkr.details != nil {
+		return updates, pkr.details, nil
+	}
+
+	packageIDs := make([]string, len(updates))
+	for n, u := range updates {
+		packageIDs[n] = u.packageID
+	}
+	details, err := pkr.updateDetails(packageIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkr.details = details
+	return updates, details, nil
+}
+
+func (pkr *PackageKitReporter) Metrics() ([]types.Metric, error) {
 	metrics := []types.Metric{}
 
 	installedFilter := PackageKitFilterBitField(PK_FILTER_ENUM_INSTALLED)
 
-	// fetch number of packages needing updates
-	if needUpdatePackages, err := pkr.countPackages("GetUpdates", 0); err == nil {
-		metrics = append(metrics, PackageCountMetric{
-			id: "needupdate",
+	// fetch packages needing updates, and break them down by kind of update
+	if updates, err := pkr.listUpdates(); err == nil {
+		metrics = append(metrics, types.NewThresholdMetric(
+			"needupdate",
+			"Packages requiring updates",
+			"The number of packages for which updates are available in the configured enabled software repositories.",
+			len(updates),
+			&pkr.needUpdateThreshold,
+		))
+
+		counts := map[PackageKitInfoEnum]int{}
+		securityPackages := []string{}
+		for _, u := range updates {
+			c := u.category()
+			counts[c]++
+			if c == PK_INFO_ENUM_SECURITY {
+				securityPackages = append(securityPackages, u.packageID)
+			}
+		}
 
-			humanName: "Packages requiring updates",
-			humanDesc: "The number of packages for which updates are available in the configured enabled software repositories.",
+		for _, uc := range updateCategories {
+			t := pkr.categoryThresholds[uc.info]
+			metrics = append(metrics, types.NewThresholdMetric(
+				"needupdate_"+uc.id,
+				uc.humanName,
+				uc.humanDesc,
+				counts[uc.info],
+				&t,
+			))
+		}
 
-			packageCount: needUpdatePackages,
-			shouldWarn:   true,
-			warningLevel: 1,
-			dangerLevel:  21,
-		})
+		metrics = append(metrics, SecurityPackagesMetric{securityPackages})
 	}
 
 	// fetch installed packages count
 	if installedPackages, err := pkr.countPackages("GetPackages", installedFilter); err == nil {
-		metrics = append(metrics, PackageCountMetric{
-			id: "installed",
-
-			humanName: "Installed packages",
-			humanDesc: "The number of packages installed on the system.",
-
-			packageCount: installedPackages,
-		})
+		metrics = append(metrics, types.NewGaugeMetric(
+			"installed",
+			"Installed packages",
+			"The number of packages installed on the system.",
+			installedPackages,
+		))
 	}
 
 	// fetch enabled repos
@@ -113,8 +418,8 @@ func (pkr PackageKitReporter) Metrics() ([]types.Metric, error) {
 			}
 
 			for {
-				s := <-ch
-				if s.Name == "org.freedesktop.PackageKit.Transaction.Finished" || s.Name == "org.freedesktop.PackageKit.Transaction.Destroy" {
+				s := nextSignal(trans.Path(), ch)
+				if s == nil || s.Name == "org.freedesktop.PackageKit.Transaction.Finished" || s.Name == "org.freedesktop.PackageKit.Transaction.Destroy" {
 					break
 				}
 				if s.Name == "org.freedesktop.PackageKit.Transaction.RepoDetail" {
@@ -137,10 +442,10 @@ func (pkr PackageKitReporter) Metrics() ([]types.Metric, error) {
 	return metrics, nil
 }
 
-func (PackageKitReporter) Hosts() ([]types.Host, error) {
+func (*PackageKitReporter) Hosts() ([]types.Host, error) {
 	return []types.Host{}, nil
 }
 
-func (PackageKitReporter) LogLines() <-chan types.ReporterLogLine {
+func (*PackageKitReporter) LogLines() <-chan types.ReporterLogLine {
 	return nil
 }
@@ -0,0 +1,35 @@
+package packagekit
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func TestNextSignalSkipsOtherTransactions(t *testing.T) {
+	const ours = dbus.ObjectPath("/1_ours")
+
+	ch := make(chan *dbus.Signal, 4)
+	ch <- &dbus.Signal{Path: "/2_theirs", Name: "org.freedesktop.PackageKit.Transaction.Finished"}
+	ch <- &dbus.Signal{Path: ours, Name: "org.freedesktop.PackageKit.Transaction.Package"}
+	ch <- &dbus.Signal{Path: "/2_theirs", Name: "org.freedesktop.PackageKit.Transaction.Package"}
+	ch <- &dbus.Signal{Path: ours, Name: "org.freedesktop.PackageKit.Transaction.Finished"}
+	close(ch)
+
+	for _, want := range []string{
+		"org.freedesktop.PackageKit.Transaction.Package",
+		"org.freedesktop.PackageKit.Transaction.Finished",
+	} {
+		s := nextSignal(ours, ch)
+		if s == nil {
+			t.Fatalf("got nil, want %s", want)
+		}
+		if s.Path != ours || s.Name != want {
+			t.Errorf("got %s from %s, want %s from %s", s.Name, s.Path, want, ours)
+		}
+	}
+
+	if s := nextSignal(ours, ch); s != nil {
+		t.Errorf("got %s from %s after the channel was closed, want nil", s.Name, s.Path)
+	}
+}
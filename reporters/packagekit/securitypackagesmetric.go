@@ -0,0 +1,33 @@
+package packagekit
+
+import (
+	"github.com/icphalanx/agent/types"
+)
+
+type SecurityPackagesMetric struct {
+	packageIDs []string
+}
+
+func (SecurityPackagesMetric) Id() string {
+	return "security_packages"
+}
+
+func (SecurityPackagesMetric) MetricType() types.MetricType {
+	return types.METRICTYPE_STRINGARRAY
+}
+
+func (spm SecurityPackagesMetric) Value() []string {
+	return spm.packageIDs
+}
+
+func (SecurityPackagesMetric) Status() types.MetricStatus {
+	return types.METRICSTATUS_NONE
+}
+
+func (SecurityPackagesMetric) HumanName() string {
+	return "Packages requiring security updates"
+}
+
+func (SecurityPackagesMetric) HumanDesc() string {
+	return "A list of the package IDs of security updates available for this host"
+}
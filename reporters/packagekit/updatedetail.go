@@ -0,0 +1,119 @@
+package packagekit
+
+import (
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// the most of a changelog we'll put in an issue
+const maxChangelogSummary = 500
+
+// updateDetail is an UpdateDetail signal emitted by GetUpdateDetail.
+type updateDetail struct {
+	packageID  string
+	vendorURLs []string
+	cveURLs    []string
+	restart    PackageKitRestartEnum
+	updateText string
+	changelog  string
+	issued     string
+}
+
+// packageName is the name part of a package ID, e.g. "openssl" from
+// "openssl;1.1.1k-5.el8_5;x86_64;rhel-8-baseos".
+func packageName(packageID string) string {
+	return strings.SplitN(packageID, ";", 2)[0]
+}
+
+// advisories extracts the vendor's advisory IDs from its URLs. Some backends
+// give each as "url;title", in which case the title is the ID; otherwise we
+// take the last part of the URL, e.g. RHSA-2021:4903 from
+// https://access.redhat.com/errata/RHSA-2021:4903.
+func (ud updateDetail) advisories() []string {
+	var ids []string
+	for _, u := range ud.vendorURLs {
+		parts := strings.SplitN(u, ";", 2)
+		id := ""
+		if len(parts) == 2 && parts[1] != "" {
+			id = parts[1]
+		} else {
+			id = parts[0][strings.LastIndexByte(strings.TrimRight(parts[0], "/"), '/')+1:]
+			id = strings.TrimRight(id, "/")
+		}
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// cves returns the CVE URLs, without any titles some backends add.
+func (ud updateDetail) cves() []string {
+	urls := make([]string, len(ud.cveURLs))
+	for n, u := range ud.cveURLs {
+		urls[n] = strings.SplitN(u, ";", 2)[0]
+	}
+	return urls
+}
+
+// changelogSummary is the first paragraph of the update text, or failing
+// that the changelog.
+func (ud updateDetail) changelogSummary() string {
+	text := strings.TrimSpace(ud.updateText)
+	if text == "" {
+		text = strings.TrimSpace(ud.changelog)
+	}
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		text = text[:i]
+	}
+	if len(text) > maxChangelogSummary {
+		text = text[:maxChangelogSummary] + "..."
+	}
+	return text
+}
+
+func stringsArg(v interface{}) []string {
+	ss, _ := v.([]string)
+	return ss
+}
+
+func (pkr *PackageKitReporter) updateDetails(packageIDs []string) ([]updateDetail, error) {
+	details := []updateDetail{}
+	if len(packageIDs) == 0 {
+		return details, nil
+	}
+
+	err := pkr.createTransaction(func(trans dbus.BusObject, ch <-chan *dbus.Signal) error {
+		err := trans.Call("org.freedesktop.PackageKit.Transaction.GetUpdateDetail", 0, packageIDs).Err
+		if err != nil {
+			return err
+		}
+
+		for {
+			s := nextSignal(trans.Path(), ch)
+			if s == nil || s.Name == "org.freedesktop.PackageKit.Transaction.Finished" || s.Name == "org.freedesktop.PackageKit.Transaction.Destroy" {
+				break
+			}
+			// UpdateDetail(s package_id, as updates, as obsoletes,
+			// as vendor_urls, as bugzilla_urls, as cve_urls, u restart,
+			// s update_text, s changelog, u state, s issued, s updated)
+			if s.Name == "org.freedesktop.PackageKit.Transaction.UpdateDetail" && len(s.Body) >= 11 {
+				ud := updateDetail{
+					vendorURLs: stringsArg(s.Body[3]),
+					cveURLs:    stringsArg(s.Body[5]),
+				}
+				ud.packageID, _ = s.Body[0].(string)
+				restart, _ := s.Body[6].(uint32)
+				ud.restart = PackageKitRestartEnum(restart)
+				ud.updateText, _ = s.Body[7].(string)
+				ud.changelog, _ = s.Body[8].(string)
+				ud.issued, _ = s.Body[10].(string)
+				details = append(details, ud)
+			}
+		}
+
+		return nil
+	})
+	return details, err
+}
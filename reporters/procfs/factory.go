@@ -0,0 +1,93 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(ProcfsReporterFactory{})
+}
+
+type ProcfsReporterFactory struct{}
+
+func (ProcfsReporterFactory) Id() string {
+	return "procfs"
+}
+
+func (prf ProcfsReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := prf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	pr := &ProcfsReporter{
+		procPath: "/proc",
+		stop:     make(chan struct{}),
+	}
+
+	var err error
+
+	if pr.sampleInterval, err = opts.Duration("sample_interval", time.Minute); err != nil {
+		return nil, err
+	}
+	if pr.sampleInterval <= 0 {
+		return nil, fmt.Errorf("sample_interval must be positive")
+	}
+
+	// load thresholds are per CPU, so that the same configuration makes
+	// sense across differently sized hosts
+	if pr.loadWarning, err = opts.Float("load_warning", 1.0); err != nil {
+		return nil, err
+	}
+	if pr.loadDanger, err = opts.Float("load_danger", 2.0); err != nil {
+		return nil, err
+	}
+
+	// the rest are percentages
+	if pr.cpuThreshold, err = opts.Threshold("cpu", types.Threshold{Warning: 80, Danger: 95}); err != nil {
+		return nil, err
+	}
+	if pr.iowaitThreshold, err = opts.Threshold("iowait", types.Threshold{Warning: 20, Danger: 50}); err != nil {
+		return nil, err
+	}
+	if pr.stealThreshold, err = opts.Threshold("steal", types.Threshold{Warning: 10, Danger: 25}); err != nil {
+		return nil, err
+	}
+	if pr.memoryThreshold, err = opts.Threshold("memory", types.Threshold{Warning: 80, Danger: 95}); err != nil {
+		return nil, err
+	}
+	if pr.swapThreshold, err = opts.Threshold("swap", types.Threshold{Warning: 50, Danger: 80}); err != nil {
+		return nil, err
+	}
+	if pr.pressureThreshold, err = opts.Threshold("pressure", types.Threshold{Warning: 10, Danger: 25}); err != nil {
+		return nil, err
+	}
+
+	// take a baseline, so that the first window starts now rather than at
+	// boot
+	pr.sampleCPU()
+	go pr.sampler()
+
+	return pr, nil
+}
+
+func (ProcfsReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// is this a LinuxHost?
+	if !h.IsLocal() {
+		return false, nil
+	}
+
+	// is procfs mounted?
+	if _, err := os.Stat(filepath.Join("/proc", "stat")); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,145 @@
+package procfs
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loadAvg reads the 1, 5 and 15 minute load averages.
+func loadAvg(procPath string) ([3]float64, error) {
+	var avg [3]float64
+
+	b, err := ioutil.ReadFile(filepath.Join(procPath, "loadavg"))
+	if err != nil {
+		return avg, err
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) < 3 {
+		return avg, fmt.Errorf("malformed loadavg %q", b)
+	}
+	for n := range avg {
+		if avg[n], err = strconv.ParseFloat(fields[n], 64); err != nil {
+			return avg, err
+		}
+	}
+	return avg, nil
+}
+
+// cpuTimes is the aggregate "cpu" line of /proc/stat, in clock ticks.
+type cpuTimes struct {
+	user, nice, system, idle, iowait, irq, softirq, steal uint64
+}
+
+func (ct cpuTimes) total() uint64 {
+	return ct.user + ct.nice + ct.system + ct.idle + ct.iowait + ct.irq + ct.softirq + ct.steal
+}
+
+// cpuStat reads the aggregate CPU times and counts the CPUs.
+func cpuStat(procPath string) (cpuTimes, int, error) {
+	var ct cpuTimes
+
+	f, err := os.Open(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return ct, 0, err
+	}
+	defer f.Close()
+
+	cpus := 0
+	found := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			cpus++
+			continue
+		}
+
+		// guest time is already included in user and nice
+		vals := make([]uint64, 8)
+		for n := range vals {
+			if n+1 >= len(fields) {
+				break
+			}
+			if vals[n], err = strconv.ParseUint(fields[n+1], 10, 64); err != nil {
+				return ct, 0, err
+			}
+		}
+		ct = cpuTimes{vals[0], vals[1], vals[2], vals[3], vals[4], vals[5], vals[6], vals[7]}
+		found = true
+	}
+	if err := sc.Err(); err != nil {
+		return ct, 0, err
+	}
+	if !found {
+		return ct, 0, fmt.Errorf("no cpu line in stat")
+	}
+	return ct, cpus, nil
+}
+
+// memInfo reads /proc/meminfo, in bytes.
+func memInfo(procPath string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mi := map[string]uint64{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// e.g. "MemAvailable:    1234567 kB"
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			v *= 1024
+		}
+		mi[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return mi, sc.Err()
+}
+
+// pressure reads the avg10 values from a /proc/pressure file, keyed by
+// "some" and "full".
+func pressure(procPath, resource string) (map[string]float64, error) {
+	f, err := os.Open(filepath.Join(procPath, "pressure", resource))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := map[string]float64{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// e.g. "some avg10=0.00 avg60=0.00 avg300=0.00 total=0"
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, kv := range fields[1:] {
+			if !strings.HasPrefix(kv, "avg10=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(kv, "avg10="), 64)
+			if err != nil {
+				return nil, err
+			}
+			p[fields[0]] = v
+		}
+	}
+	return p, sc.Err()
+}
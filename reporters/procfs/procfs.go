@@ -0,0 +1,254 @@
+package procfs
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+// ProcfsReporter reports load, CPU, memory and pressure from /proc.
+type ProcfsReporter struct {
+	procPath string
+
+	loadWarning float64
+	loadDanger  float64
+
+	cpuThreshold      types.Threshold
+	iowaitThreshold   types.Threshold
+	stealThreshold    types.Threshold
+	memoryThreshold   types.Threshold
+	swapThreshold     types.Threshold
+	pressureThreshold types.Threshold
+
+	// CPU utilisation is measured over the window between the last two
+	// samples, which we take every sampleInterval regardless of how often
+	// Metrics is called
+	sampleInterval time.Duration
+	stop           chan struct{}
+
+	mu      sync.Mutex
+	prevCPU cpuTimes
+	lastCPU cpuTimes
+	// false until we have taken two samples, so prevCPU is real
+	cpuReady bool
+	cpus     int
+	cpuErr   error
+}
+
+func (*ProcfsReporter) Id() string {
+	return "procfs"
+}
+
+func (*ProcfsReporter) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (*ProcfsReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (*ProcfsReporter) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+func percent(n, d uint64) int {
+	if d == 0 {
+		return 0
+	}
+	return int(math.Floor(float64(n)*100/float64(d) + 0.5))
+}
+
+// sampleCPU reads the CPU times, moving the window along by one sample.
+func (pr *ProcfsReporter) sampleCPU() {
+	ct, cpus, err := cpuStat(pr.procPath)
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.cpuErr = err
+	if err != nil {
+		return
+	}
+	pr.cpuReady = pr.lastCPU != (cpuTimes{})
+	pr.prevCPU, pr.lastCPU = pr.lastCPU, ct
+	pr.cpus = cpus
+}
+
+func (pr *ProcfsReporter) sampler() {
+	ticker := time.NewTicker(pr.sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pr.stop:
+			return
+		case <-ticker.C:
+			pr.sampleCPU()
+		}
+	}
+}
+
+// Close stops sampling.
+func (pr *ProcfsReporter) Close() error {
+	close(pr.stop)
+	return nil
+}
+
+func (pr *ProcfsReporter) cpuMetrics(metrics []types.Metric, last, ct cpuTimes) []types.Metric {
+	d := cpuTimes{
+		user:    ct.user - last.user,
+		nice:    ct.nice - last.nice,
+		system:  ct.system - last.system,
+		idle:    ct.idle - last.idle,
+		iowait:  ct.iowait - last.iowait,
+		irq:     ct.irq - last.irq,
+		softirq: ct.softirq - last.softirq,
+		steal:   ct.steal - last.steal,
+	}
+	total := d.total()
+
+	modes := []struct {
+		id    string
+		name  string
+		ticks uint64
+		t     *types.Threshold
+	}{
+		{"cpu_user", "user", d.user, nil},
+		{"cpu_nice", "nice", d.nice, nil},
+		{"cpu_system", "system", d.system, nil},
+		{"cpu_idle", "idle", d.idle, nil},
+		{"cpu_iowait", "I/O wait", d.iowait, &pr.iowaitThreshold},
+		{"cpu_irq", "IRQ", d.irq, nil},
+		{"cpu_softirq", "soft IRQ", d.softirq, nil},
+		{"cpu_steal", "steal", d.steal, &pr.stealThreshold},
+	}
+	for _, m := range modes {
+		metrics = append(metrics, types.NewThresholdMetric(
+			m.id,
+			"CPU time in "+m.name,
+			"The percentage of CPU time spent in "+m.name+" mode in the last sampling interval, across all CPUs.",
+			percent(m.ticks, total),
+			m.t,
+		))
+	}
+
+	metrics = append(metrics, types.NewThresholdMetric(
+		"cpu_busy",
+		"CPU utilisation",
+		"The percentage of CPU time spent neither idle nor waiting for I/O in the last sampling interval, across all CPUs.",
+		percent(total-d.idle-d.iowait, total),
+		&pr.cpuThreshold,
+	))
+
+	return metrics
+}
+
+func (pr *ProcfsReporter) loadMetrics(metrics []types.Metric, avg [3]float64, cpus int) []types.Metric {
+	if cpus < 1 {
+		cpus = 1
+	}
+	t := &types.Threshold{
+		Warning: int(pr.loadWarning * float64(cpus) * 100),
+		Danger:  int(pr.loadDanger * float64(cpus) * 100),
+	}
+
+	for n, period := range []string{"1", "5", "15"} {
+		metrics = append(metrics, types.NewThresholdMetric(
+			"loadavg"+period,
+			period+" minute load average",
+			"The "+period+" minute load average, in hundredths (so 150 is a load of 1.5).",
+			int(math.Floor(avg[n]*100+0.5)),
+			t,
+		))
+	}
+	return metrics
+}
+
+func (pr *ProcfsReporter) memoryMetrics(metrics []types.Metric, mi map[string]uint64) []types.Metric {
+	memTotal, memAvailable := mi["MemTotal"], mi["MemAvailable"]
+	if _, ok := mi["MemAvailable"]; !ok {
+		// kernels before 3.14 don't estimate it, so make do with what's
+		// free or easily reclaimed
+		memAvailable = mi["MemFree"] + mi["Buffers"] + mi["Cached"]
+	}
+	swapTotal, swapFree := mi["SwapTotal"], mi["SwapFree"]
+
+	metrics = append(metrics,
+		types.NewGaugeMetric("mem_total", "Total memory", "The total usable memory, in bytes.", int(memTotal)),
+		types.NewGaugeMetric("mem_available", "Available memory", "The memory available for starting new applications without swapping, in bytes.", int(memAvailable)),
+		types.NewThresholdMetric("mem_used", "Memory utilisation", "The percentage of memory which is not available for starting new applications without swapping.", percent(memTotal-memAvailable, memTotal), &pr.memoryThreshold),
+		types.NewGaugeMetric("swap_total", "Total swap", "The total swap space, in bytes.", int(swapTotal)),
+	)
+
+	// no point warning about swap on hosts without any
+	var swapThreshold *types.Threshold
+	if swapTotal > 0 {
+		swapThreshold = &pr.swapThreshold
+	}
+	metrics = append(metrics, types.NewThresholdMetric("swap_used", "Swap utilisation", "The percentage of swap space in use.", percent(swapTotal-swapFree, swapTotal), swapThreshold))
+
+	return metrics
+}
+
+func (pr *ProcfsReporter) pressureMetrics(metrics []types.Metric) []types.Metric {
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		p, err := pressure(pr.procPath, resource)
+		if err != nil {
+			// older kernels, or PSI disabled
+			continue
+		}
+
+		for _, kind := range []string{"some", "full"} {
+			v, ok := p[kind]
+			if !ok {
+				continue
+			}
+			desc := "The percentage of the last 10 seconds in which some tasks were stalled waiting for " + resource + "."
+			if kind == "full" {
+				desc = "The percentage of the last 10 seconds in which all non-idle tasks were stalled waiting for " + resource + "."
+			}
+			metrics = append(metrics, types.NewThresholdMetric(
+				"pressure_"+resource+"_"+kind,
+				"Pressure stall ("+resource+", "+kind+")",
+				desc,
+				int(math.Floor(v+0.5)),
+				&pr.pressureThreshold,
+			))
+		}
+	}
+	return metrics
+}
+
+func (pr *ProcfsReporter) Metrics() ([]types.Metric, error) {
+	metrics := []types.Metric{}
+
+	pr.mu.Lock()
+	last, ct, ready, cpus, err := pr.prevCPU, pr.lastCPU, pr.cpuReady, pr.cpus, pr.cpuErr
+	pr.mu.Unlock()
+
+	if err != nil {
+		log.Println("procfs: failed to read CPU times:", err)
+	} else if ready {
+		// until the first sampling interval is up we only have the baseline
+		// taken in Create, and nothing to measure against it
+		metrics = pr.cpuMetrics(metrics, last, ct)
+	}
+
+	if avg, err := loadAvg(pr.procPath); err != nil {
+		log.Println("procfs: failed to read load average:", err)
+	} else {
+		metrics = pr.loadMetrics(metrics, avg, cpus)
+	}
+
+	if mi, err := memInfo(pr.procPath); err != nil {
+		log.Println("procfs: failed to read memory info:", err)
+	} else {
+		metrics = pr.memoryMetrics(metrics, mi)
+	}
+
+	metrics = pr.pressureMetrics(metrics)
+
+	return metrics, nil
+}
@@ -0,0 +1,62 @@
+package procfs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/icphalanx/agent/types"
+)
+
+func writeProc(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func metricValues(t *testing.T, pr *ProcfsReporter) map[string]int {
+	t.Helper()
+	metrics, err := pr.Metrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]int{}
+	for _, m := range metrics {
+		values[m.Id()] = m.(types.MetricUncountable).Value()
+	}
+	return values
+}
+
+func TestCPUIsMeasuredFromTheBaseline(t *testing.T) {
+	dir := t.TempDir()
+	pr := &ProcfsReporter{procPath: dir}
+
+	// lots of time idle since boot...
+	writeProc(t, dir, "stat", "cpu  100 0 0 9900 0 0 0 0\ncpu0 100 0 0 9900 0 0 0 0\n")
+	pr.sampleCPU()
+	if _, ok := metricValues(t, pr)["cpu_busy"]; ok {
+		t.Error("reported CPU utilisation from the baseline alone")
+	}
+
+	// ...but busy since we started
+	writeProc(t, dir, "stat", "cpu  190 0 0 9910 0 0 0 0\ncpu0 190 0 0 9910 0 0 0 0\n")
+	pr.sampleCPU()
+	if busy := metricValues(t, pr)["cpu_busy"]; busy != 90 {
+		t.Errorf("cpu_busy is %d, want 90", busy)
+	}
+}
+
+func TestMemAvailableFallback(t *testing.T) {
+	dir := t.TempDir()
+	pr := &ProcfsReporter{procPath: dir}
+
+	writeProc(t, dir, "meminfo", "MemTotal: 1000 kB\nMemFree: 200 kB\nBuffers: 100 kB\nCached: 300 kB\n")
+	values := metricValues(t, pr)
+	if got := values["mem_available"]; got != 600*1024 {
+		t.Errorf("mem_available is %d, want MemFree+Buffers+Cached", got)
+	}
+	if got := values["mem_used"]; got != 40 {
+		t.Errorf("mem_used is %d, want 40", got)
+	}
+}
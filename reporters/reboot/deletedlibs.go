@@ -0,0 +1,119 @@
+package reboot
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const deletedSuffix = " (deleted)"
+
+// staleProcess is a process still running code which has since been deleted
+// (usually replaced by a package update).
+type staleProcess struct {
+	pid   int
+	comm  string
+	unit  string   // the systemd service it belongs to, if any
+	files []string // the deleted files it has mapped
+}
+
+// isCode is whether path is somewhere we'd expect to find libraries or
+// executables, as opposed to e.g. a deleted temporary file or shared memory.
+func isCode(path string) bool {
+	for _, dir := range []string{"/lib", "/lib64", "/usr/lib", "/usr/lib64", "/usr/libexec", "/bin", "/sbin", "/usr/bin", "/usr/sbin"} {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// deletedMappings reads which deleted libraries or executables pid has
+// mapped.
+func deletedMappings(procPath string, pid int) ([]string, error) {
+	f, err := os.Open(filepath.Join(procPath, strconv.Itoa(pid), "maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := map[string]bool{}
+	var files []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// e.g. 7f2c1a000000-7f2c1a1b5000 r-xp 00000000 08:01 1234 /usr/lib/libc.so.6 (deleted)
+		line := sc.Text()
+		if !strings.HasSuffix(line, deletedSuffix) {
+			continue
+		}
+		i := strings.IndexByte(line, '/')
+		if i < 0 {
+			continue
+		}
+		path := strings.TrimSuffix(line[i:], deletedSuffix)
+		if !isCode(path) || seen[path] {
+			continue
+		}
+		seen[path] = true
+		files = append(files, path)
+	}
+	return files, sc.Err()
+}
+
+// serviceOf finds which systemd service pid is part of from its cgroup, e.g.
+// "0::/system.slice/nginx.service".
+func serviceOf(procPath string, pid int) string {
+	b, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		i := strings.LastIndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		parts := strings.Split(line[i+1:], "/")
+		for n := len(parts) - 1; n >= 0; n-- {
+			if strings.HasSuffix(parts[n], ".service") {
+				return parts[n]
+			}
+		}
+	}
+	return ""
+}
+
+// staleProcesses finds every process we can see which is running deleted
+// code. Processes we aren't allowed to look at are skipped.
+func staleProcesses(procPath string) ([]staleProcess, error) {
+	fis, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []staleProcess
+	for _, fi := range fis {
+		pid, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+
+		files, err := deletedMappings(procPath, pid)
+		if err != nil || len(files) == 0 {
+			// it's exited, or isn't ours to look at
+			continue
+		}
+
+		comm, _ := ioutil.ReadFile(filepath.Join(procPath, fi.Name(), "comm"))
+		procs = append(procs, staleProcess{
+			pid:   pid,
+			comm:  strings.TrimSpace(string(comm)),
+			unit:  serviceOf(procPath, pid),
+			files: files,
+		})
+	}
+	return procs, nil
+}
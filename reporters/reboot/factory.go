@@ -0,0 +1,58 @@
+package reboot
+
+import (
+	"time"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(RebootReporterFactory{})
+}
+
+type RebootReporterFactory struct{}
+
+func (RebootReporterFactory) Id() string {
+	return "reboot"
+}
+
+func (rrf RebootReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := rrf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	// reading every process's maps is expensive, so we don't do it on every
+	// tick
+	scanInterval, err := opts.Duration("scan_interval", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	// services which can't sensibly be restarted on their own, so that
+	// running stale code means rebooting
+	rebootServices, err := opts.Strings("reboot_services", defaultRebootServices)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := &RebootReporter{
+		procPath:           "/proc",
+		modulesPath:        "/lib/modules",
+		rebootRequiredPath: "/run/reboot-required",
+		scanInterval:       scanInterval,
+		rebootServices:     map[string]bool{},
+		issues:             types.NewIssueTracker(h),
+	}
+	for _, s := range rebootServices {
+		rr.rebootServices[s] = true
+	}
+	return rr, nil
+}
+
+func (RebootReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// is this a LinuxHost?
+	return h.IsLocal(), nil
+}
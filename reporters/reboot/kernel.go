@@ -0,0 +1,108 @@
+package reboot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runningKernel is the release of the running kernel, as in uname -r.
+func runningKernel() (string, error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 0, len(uts.Release))
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b), nil
+}
+
+// installedKernels lists the kernels with modules installed under
+// modulesPath.
+func installedKernels(modulesPath string) ([]string, error) {
+	fis, err := ioutil.ReadDir(modulesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var kernels []string
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		// a directory left behind with only extra modules in it doesn't
+		// mean the kernel is installed
+		if _, err := os.Stat(filepath.Join(modulesPath, fi.Name(), "modules.dep")); err != nil {
+			continue
+		}
+		kernels = append(kernels, fi.Name())
+	}
+	return kernels, nil
+}
+
+// splitVersion splits a version into runs of digits and non-digits, so that
+// "5.10.0-21-amd64" becomes "5", ".", "10", ".", "0", "-", "21", "-amd", "64".
+func splitVersion(v string) []string {
+	var parts []string
+	start := 0
+	for i := 1; i <= len(v); i++ {
+		if i == len(v) || isDigit(v[i]) != isDigit(v[start]) {
+			parts = append(parts, v[start:i])
+			start = i
+		}
+	}
+	return parts
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// compareVersions compares two kernel releases, treating runs of digits as
+// numbers, and returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	ap, bp := splitVersion(a), splitVersion(b)
+	for n := 0; n < len(ap) && n < len(bp); n++ {
+		if isDigit(ap[n][0]) && isDigit(bp[n][0]) {
+			an, _ := strconv.ParseUint(ap[n], 10, 64)
+			bn, _ := strconv.ParseUint(bp[n], 10, 64)
+			switch {
+			case an < bn:
+				return -1
+			case an > bn:
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(ap[n], bp[n]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(ap) < len(bp):
+		return -1
+	case len(ap) > len(bp):
+		return 1
+	}
+	return 0
+}
+
+// newestKernel returns the newest of kernels.
+func newestKernel(kernels []string) string {
+	newest := ""
+	for _, k := range kernels {
+		if newest == "" || compareVersions(k, newest) > 0 {
+			newest = k
+		}
+	}
+	return newest
+}
@@ -0,0 +1,201 @@
+package reboot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/types"
+)
+
+var defaultRebootServices = []string{
+	"dbus.service",
+	"dbus-broker.service",
+	"systemd-logind.service",
+}
+
+// RebootReporter raises issues when updates have been installed which won't
+// take effect until the host is rebooted, or some of its services restarted.
+type RebootReporter struct {
+	procPath           string
+	modulesPath        string
+	rebootRequiredPath string
+
+	scanInterval   time.Duration
+	rebootServices map[string]bool
+
+	mu       sync.Mutex
+	lastScan time.Time
+	stale    []staleProcess
+
+	issues *types.IssueTracker
+}
+
+func (*RebootReporter) Id() string {
+	return "reboot"
+}
+
+func (*RebootReporter) Metrics() ([]types.Metric, error) {
+	return []types.Metric{}, nil
+}
+
+func (*RebootReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (*RebootReporter) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+// kernelReasons explains why the running kernel is out of date, if it is.
+func (rr *RebootReporter) kernelReasons() []string {
+	running, err := runningKernel()
+	if err != nil {
+		log.Println("reboot: failed to find running kernel:", err)
+		return nil
+	}
+	installed, err := installedKernels(rr.modulesPath)
+	if err != nil {
+		log.Println("reboot: failed to find installed kernels:", err)
+		return nil
+	}
+	if len(installed) == 0 {
+		// probably a container
+		return nil
+	}
+
+	if newest := newestKernel(installed); compareVersions(newest, running) > 0 {
+		return []string{fmt.Sprintf("the running kernel is %s, but %s is installed", running, newest)}
+	}
+	for _, k := range installed {
+		if k == running {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("the running kernel %s has been uninstalled", running)}
+}
+
+// rebootRequiredReasons reads Debian's /run/reboot-required, which packages
+// touch when they need a reboot.
+func (rr *RebootReporter) rebootRequiredReasons() []string {
+	if _, err := os.Stat(rr.rebootRequiredPath); err != nil {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(rr.rebootRequiredPath + ".pkgs")
+	if err != nil {
+		return []string{rr.rebootRequiredPath + " exists"}
+	}
+	pkgs := strings.Fields(string(b))
+	sort.Strings(pkgs)
+	return []string{fmt.Sprintf("%s exists, because of updates to %s", rr.rebootRequiredPath, strings.Join(dedupe(pkgs), ", "))}
+}
+
+func dedupe(ss []string) []string {
+	out := ss[:0]
+	for n, s := range ss {
+		if n == 0 || s != ss[n-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// staleProcesses rescans for processes running deleted code, if it's been
+// long enough since we last did.
+func (rr *RebootReporter) staleProcesses(now time.Time) []staleProcess {
+	if !rr.lastScan.IsZero() && now.Sub(rr.lastScan) < rr.scanInterval {
+		return rr.stale
+	}
+
+	stale, err := staleProcesses(rr.procPath)
+	if err != nil {
+		log.Println("reboot: failed to scan processes:", err)
+		return rr.stale
+	}
+	rr.stale, rr.lastScan = stale, now
+	return stale
+}
+
+func (rr *RebootReporter) Issues() ([]types.Issue, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	now := time.Now()
+
+	rebootReasons := append(rr.kernelReasons(), rr.rebootRequiredReasons()...)
+
+	// split the stale processes into those we can fix by restarting their
+	// service, and those we can't
+	restart := map[string][]string{}
+	for _, p := range rr.staleProcesses(now) {
+		switch {
+		case p.pid == 1:
+			rebootReasons = append(rebootReasons, fmt.Sprintf("systemd is running deleted code (%s)", strings.Join(p.files, ", ")))
+		case rr.rebootServices[p.unit]:
+			rebootReasons = append(rebootReasons, fmt.Sprintf("%s is running deleted code (%s)", p.unit, strings.Join(p.files, ", ")))
+		case p.unit != "":
+			restart[p.unit] = append(restart[p.unit], p.files...)
+		default:
+			// e.g. a user's login session
+			name := fmt.Sprintf("%s (pid %d)", p.comm, p.pid)
+			restart[name] = append(restart[name], p.files...)
+		}
+	}
+
+	issues := []types.IssueDetails{}
+	if len(rebootReasons) > 0 {
+		issues = append(issues, types.IssueDetails{
+			Id:          "reboot-required",
+			Severity:    types.ISSUESEVERITY_WARNING,
+			Title:       "Reboot required",
+			Description: "This host needs rebooting to pick up updates: " + strings.Join(rebootReasons, "; ") + ".",
+			Remediation: "Reboot the host at a convenient time.",
+		})
+	}
+
+	if len(restart) > 0 {
+		names := make([]string, 0, len(restart))
+		for name := range restart {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var services []string
+		lines := make([]string, len(names))
+		for n, name := range names {
+			lines[n] = fmt.Sprintf("%s (%s)", name, strings.Join(dedupe(sortedCopy(restart[name])), ", "))
+			if strings.HasSuffix(name, ".service") {
+				services = append(services, name)
+			}
+		}
+
+		remediation := "Restart the processes listed, or reboot the host."
+		if len(services) == len(names) {
+			remediation = "Run `systemctl restart " + strings.Join(services, " ") + "`, or reboot the host."
+		} else if len(services) > 0 {
+			remediation = "Run `systemctl restart " + strings.Join(services, " ") + "`, restart the other processes listed, or reboot the host."
+		}
+
+		issues = append(issues, types.IssueDetails{
+			Id:          "restart-required",
+			Severity:    types.ISSUESEVERITY_INFO,
+			Title:       "Services need restarting",
+			Description: "These are still running libraries or executables which have since been updated: " + strings.Join(lines, "; ") + ".",
+			Remediation: remediation,
+		})
+	}
+
+	return rr.issues.Issues(issues, now), nil
+}
+
+func sortedCopy(ss []string) []string {
+	c := append([]string{}, ss...)
+	sort.Strings(c)
+	return c
+}
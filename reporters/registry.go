@@ -1,36 +1,223 @@
 package reporters
 
 import (
+	"fmt"
 	"github.com/icphalanx/agent/types"
+	"io"
 	"log"
+	"reflect"
 )
 
 var registry []types.ReporterFactory = []types.ReporterFactory{}
 
+// ErrNotApplicable is returned by a factory's Create when asked for a
+// reporter on a host it doesn't apply to.
+var ErrNotApplicable = fmt.Errorf("reporter not applicable to this host")
+
 func Register(r types.ReporterFactory) error {
 	registry = append(registry, r)
 	return nil
 }
 
-func GenerateFor(h types.Host) []types.Reporter {
+// Selection controls which registered factories GenerateFor instantiates,
+// and with which options.
+type Selection struct {
+	// if non-empty, only these factories are considered
+	Allow []string
+	// these factories are never considered
+	Deny []string
+
+	// options handed to each factory's Create, keyed by factory id
+	Options map[string]types.ReporterOptions
+
+	// factories which only stand in for another, keyed by the id of the
+	// stand-in: it is only created if the other's reporter wasn't
+	Fallback map[string]string
+}
+
+func contains(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (sel Selection) allowed(id string) (bool, string) {
+	if len(sel.Allow) > 0 && !contains(sel.Allow, id) {
+		return false, "not in allow list"
+	}
+	if contains(sel.Deny, id) {
+		return false, "in deny list"
+	}
+	return true, ""
+}
+
+// warnUnknown complains about any factory ids in sel which nothing registered,
+// since they're most likely typos.
+func (sel Selection) warnUnknown() {
+	known := make([]string, len(registry))
+	for n, rf := range registry {
+		known[n] = rf.Id()
+	}
+
+	ids := append(append([]string{}, sel.Allow...), sel.Deny...)
+	for id := range sel.Options {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		if !contains(known, id) {
+			log.Println("reporter", id, "is configured but no such reporter exists")
+		}
+	}
+}
+
+// ordered returns the registered factories with any fallbacks in sel last,
+// so that we know whether the reporters they stand in for were created by
+// the time we get to them.
+func (sel Selection) ordered() []types.ReporterFactory {
+	rfs := make([]types.ReporterFactory, 0, len(registry))
+	fallbacks := []types.ReporterFactory{}
+	for _, rf := range registry {
+		if _, ok := sel.Fallback[rf.Id()]; ok {
+			fallbacks = append(fallbacks, rf)
+		} else {
+			rfs = append(rfs, rf)
+		}
+	}
+	return append(rfs, fallbacks...)
+}
+
+func hasReporter(rs []types.Reporter, id string) bool {
+	for _, r := range rs {
+		if r.Id() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// generate creates rf's reporter for h, or returns why it didn't. created
+// holds the reporters made so far, which decides whether a fallback is needed.
+func generate(h types.Host, sel Selection, rf types.ReporterFactory, created []types.Reporter) (types.Reporter, string) {
+	if ok, why := sel.allowed(rf.Id()); !ok {
+		log.Println(rf.Id(), "disabled for", h.Id(), why)
+		return nil, why
+	}
+
+	if primary, ok := sel.Fallback[rf.Id()]; ok && hasReporter(created, primary) {
+		log.Println(rf.Id(), "not needed for", h.Id(), "as", primary, "is running")
+		return nil, fmt.Sprintf("%s is running instead", primary)
+	}
+
+	opts := sel.Options[rf.Id()]
+	applicable, err := rf.ApplicableTo(h, opts)
+	if err != nil {
+		log.Println(rf.Id(), "not applicable to", h.Id(), err)
+		return nil, fmt.Sprintf("not applicable: %v", err)
+	} else if !applicable {
+		log.Println(rf.Id(), "not applicable to", h.Id(), "for an unknown reason")
+		return nil, "not applicable"
+	}
+
+	log.Println(rf.Id(), "applicable to", h.Id())
+	r, err := rf.Create(h, opts)
+	if err != nil {
+		log.Println(rf.Id(), "error instantiating", err)
+		return nil, fmt.Sprintf("error instantiating: %v", err)
+	} else if r == nil {
+		log.Println(rf.Id(), "created no reporter")
+		return nil, "created no reporter"
+	}
+	return r, ""
+}
+
+func GenerateFor(h types.Host, sel Selection) []types.Reporter {
+	sel.warnUnknown()
+
 	ret := make([]types.Reporter, 0)
+	skipped := new(SkippedReporter)
+	for _, rf := range sel.ordered() {
+		r, why := generate(h, sel, rf, ret)
+		if r == nil {
+			skipped.add(rf.Id(), why)
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return append(ret, skipped)
+}
+
+// Affected returns the ids of the registered factories whose reporters need
+// re-creating to go from sel to other, because they have been enabled or
+// disabled, their options have changed, or they are a fallback for one which
+// has.
+func (sel Selection) Affected(other Selection) []string {
+	ids := []string{}
+	for _, rf := range registry {
+		id := rf.Id()
+		was, _ := sel.allowed(id)
+		is, _ := other.allowed(id)
+		if was != is || !reflect.DeepEqual(sel.Options[id], other.Options[id]) || sel.Fallback[id] != other.Fallback[id] {
+			ids = append(ids, id)
+		}
+	}
 	for _, rf := range registry {
-		applicable, err := rf.ApplicableTo(h)
-		if err != nil {
-			log.Println(rf.Id(), "not applicable to", h.Id(), err)
+		id := rf.Id()
+		if primary, ok := other.Fallback[id]; ok && contains(ids, primary) && !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// RegenerateFor re-creates those of current, as made by GenerateFor, whose
+// factories are listed in ids, and returns the new set of reporters along
+// with the ones it created. The reporters it replaces are closed first, so
+// that their replacements can take over any sockets they held.
+func RegenerateFor(h types.Host, current []types.Reporter, sel Selection, ids []string) (next, created []types.Reporter) {
+	sel.warnUnknown()
+
+	byId := map[string]types.Reporter{}
+	oldSkipped := new(SkippedReporter)
+	for _, r := range current {
+		if sr, ok := r.(*SkippedReporter); ok {
+			oldSkipped = sr
 			continue
-		} else if !applicable {
-			log.Println(rf.Id(), "not applicable to", h.Id(), "for an unknown reason")
+		}
+		byId[r.Id()] = r
+
+		if !contains(ids, r.Id()) {
 			continue
 		}
+		if c, ok := r.(io.Closer); ok {
+			log.Println(r.Id(), "closing to apply new configuration")
+			if err := c.Close(); err != nil {
+				log.Println(r.Id(), "failed to close:", err)
+			}
+		}
+	}
 
-		log.Println(rf.Id(), "applicable to", h.Id())
-		r, err := rf.Create(h)
-		if err != nil {
-			log.Println(rf.Id(), "error instantiating", err)
+	next = make([]types.Reporter, 0)
+	skipped := new(SkippedReporter)
+	for _, rf := range sel.ordered() {
+		if !contains(ids, rf.Id()) {
+			if r, ok := byId[rf.Id()]; ok {
+				next = append(next, r)
+			} else if why, ok := oldSkipped.reason(rf.Id()); ok {
+				skipped.add(rf.Id(), why)
+			}
 			continue
 		}
-		ret = append(ret, r)
+
+		r, why := generate(h, sel, rf, next)
+		if r == nil {
+			skipped.add(rf.Id(), why)
+			continue
+		}
+		next = append(next, r)
+		created = append(created, r)
 	}
-	return ret
+	return append(next, skipped), created
 }
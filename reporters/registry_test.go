@@ -0,0 +1,165 @@
+package reporters
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/icphalanx/agent/types"
+)
+
+type testHost struct{}
+
+func (testHost) Id() string                           { return "test" }
+func (testHost) IsLocal() bool                        { return true }
+func (testHost) HumanName() (string, error)           { return "test", nil }
+func (testHost) Parent() (types.Host, error)          { return nil, nil }
+func (testHost) Reporters() ([]types.Reporter, error) { return nil, nil }
+
+// testReporter remembers the options it was created with, and whether it
+// has been closed.
+type testReporter struct {
+	id     string
+	opts   types.ReporterOptions
+	closed bool
+}
+
+func (tr *testReporter) Id() string {
+	return tr.id
+}
+
+func (tr *testReporter) Close() error {
+	tr.closed = true
+	return nil
+}
+
+func (testReporter) Issues() ([]types.Issue, error)         { return nil, nil }
+func (testReporter) Metrics() ([]types.Metric, error)       { return nil, nil }
+func (testReporter) Hosts() ([]types.Host, error)           { return nil, nil }
+func (testReporter) LogLines() <-chan types.ReporterLogLine { return nil }
+
+type testFactory string
+
+func (tf testFactory) Id() string {
+	return string(tf)
+}
+
+func (tf testFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	return true, nil
+}
+
+func (tf testFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	return &testReporter{id: string(tf), opts: opts}, nil
+}
+
+func withTestRegistry(t *testing.T, ids ...string) {
+	old := registry
+	registry = nil
+	for _, id := range ids {
+		Register(testFactory(id))
+	}
+	t.Cleanup(func() { registry = old })
+}
+
+func reportersById(rs []types.Reporter) map[string]types.Reporter {
+	byId := map[string]types.Reporter{}
+	for _, r := range rs {
+		byId[r.Id()] = r
+	}
+	return byId
+}
+
+func TestRegenerateFor(t *testing.T) {
+	withTestRegistry(t, "same", "changed", "enabled", "disabled", "off")
+
+	before := Selection{
+		Deny: []string{"enabled", "off"},
+		Options: map[string]types.ReporterOptions{
+			"same":    {"a": 1},
+			"changed": {"a": 1},
+		},
+	}
+	after := Selection{
+		Deny: []string{"disabled", "off"},
+		Options: map[string]types.ReporterOptions{
+			"same":    {"a": 1},
+			"changed": {"a": 2},
+		},
+	}
+
+	current := GenerateFor(testHost{}, before)
+	old := reportersById(current)
+
+	affected := before.Affected(after)
+	if want := []string{"changed", "enabled", "disabled"}; !reflect.DeepEqual(affected, want) {
+		t.Fatalf("Affected returned %v, want %v", affected, want)
+	}
+
+	next, created := RegenerateFor(testHost{}, current, after, affected)
+	now := reportersById(next)
+
+	if now["same"] != old["same"] || old["same"].(*testReporter).closed {
+		t.Error("unaffected reporter was replaced")
+	}
+	if !old["changed"].(*testReporter).closed {
+		t.Error("reporter with changed options wasn't closed")
+	}
+	if r, ok := now["changed"].(*testReporter); !ok || r == old["changed"] || r.opts["a"] != 2 {
+		t.Error("reporter with changed options wasn't re-created with them")
+	}
+	if _, ok := now["enabled"]; !ok {
+		t.Error("newly enabled reporter wasn't created")
+	}
+	if _, ok := now["disabled"]; ok || !old["disabled"].(*testReporter).closed {
+		t.Error("newly disabled reporter wasn't closed and removed")
+	}
+	if len(created) != 2 {
+		t.Errorf("created %d reporters, want 2", len(created))
+	}
+
+	metrics, err := now["registry"].Metrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"disabled: in deny list", "off: in deny list"}
+	if got := metrics[0].(SkippedReportersMetric).Value(); !reflect.DeepEqual(got, want) {
+		t.Errorf("skipped reporters are %v, want %v", got, want)
+	}
+}
+
+// inapplicableFactory never applies, like journald on a host without it.
+type inapplicableFactory struct{ testFactory }
+
+func (inapplicableFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	return false, nil
+}
+
+func TestFallback(t *testing.T) {
+	withTestRegistry(t, "fallback", "primary")
+	sel := Selection{Fallback: map[string]string{"fallback": "primary"}}
+
+	byId := reportersById(GenerateFor(testHost{}, sel))
+	if _, ok := byId["fallback"]; ok {
+		t.Error("fallback was created alongside the reporter it stands in for")
+	}
+	if _, ok := byId["primary"]; !ok {
+		t.Error("primary wasn't created")
+	}
+
+	// once primary is disabled, the fallback takes over
+	current := GenerateFor(testHost{}, sel)
+	next := Selection{Deny: []string{"primary"}, Fallback: sel.Fallback}
+	affected := sel.Affected(next)
+	if want := []string{"primary", "fallback"}; !reflect.DeepEqual(affected, want) {
+		t.Fatalf("Affected returned %v, want %v", affected, want)
+	}
+	current, _ = RegenerateFor(testHost{}, current, next, affected)
+	if _, ok := reportersById(current)["fallback"]; !ok {
+		t.Error("fallback wasn't created once primary was disabled")
+	}
+
+	// and likewise if primary doesn't apply
+	registry = []types.ReporterFactory{testFactory("fallback"), inapplicableFactory{"primary"}}
+	if _, ok := reportersById(GenerateFor(testHost{}, sel))["fallback"]; !ok {
+		t.Error("fallback wasn't created when primary doesn't apply")
+	}
+}
@@ -0,0 +1,79 @@
+package reporters
+
+import (
+	"fmt"
+	"github.com/icphalanx/agent/types"
+)
+
+// SkippedReporter reports on which reporters GenerateFor didn't create for a
+// host, and why.
+type SkippedReporter struct {
+	ids     []string
+	reasons map[string]string
+}
+
+func (sr *SkippedReporter) add(id, why string) {
+	if sr.reasons == nil {
+		sr.reasons = map[string]string{}
+	}
+	sr.ids = append(sr.ids, id)
+	sr.reasons[id] = why
+}
+
+// reason returns why id was skipped, if it was.
+func (sr *SkippedReporter) reason(id string) (string, bool) {
+	why, ok := sr.reasons[id]
+	return why, ok
+}
+
+func (SkippedReporter) Id() string {
+	return "registry"
+}
+
+func (SkippedReporter) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (sr SkippedReporter) Metrics() ([]types.Metric, error) {
+	skipped := make([]string, len(sr.ids))
+	for n, id := range sr.ids {
+		skipped[n] = fmt.Sprintf("%s: %s", id, sr.reasons[id])
+	}
+	return []types.Metric{SkippedReportersMetric{skipped}}, nil
+}
+
+func (SkippedReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (SkippedReporter) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+type SkippedReportersMetric struct {
+	skipped []string
+}
+
+func (SkippedReportersMetric) Id() string {
+	return "skipped"
+}
+
+func (SkippedReportersMetric) MetricType() types.MetricType {
+	return types.METRICTYPE_STRINGARRAY
+}
+
+func (srm SkippedReportersMetric) Value() []string {
+	return srm.skipped
+}
+
+func (SkippedReportersMetric) Status() types.MetricStatus {
+	return types.METRICSTATUS_NONE
+}
+
+func (SkippedReportersMetric) HumanName() string {
+	return "Skipped reporters"
+}
+
+func (SkippedReportersMetric) HumanDesc() string {
+	return "Reporters which were not started on this host, and the reason why"
+}
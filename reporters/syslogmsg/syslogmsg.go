@@ -0,0 +1,178 @@
+// Package syslogmsg parses syslog messages for the reporters which receive
+// them.
+package syslogmsg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jeromer/syslogparser"
+	"github.com/jeromer/syslogparser/rfc3164"
+	"github.com/jeromer/syslogparser/rfc5424"
+)
+
+// Message is a syslog message, split into the parts we send upstream.
+type Message struct {
+	Content   string
+	Timestamp time.Time
+	Tags      []string
+
+	// the hostname the sender claims to be, if any
+	Hostname string
+}
+
+// Raw wraps a message we couldn't parse so that it can be sent on as-is
+// rather than lost.
+func Raw(b []byte) Message {
+	return Message{
+		Content:   strings.TrimRight(string(b), "\r\n\x00"),
+		Timestamp: time.Now(),
+		Tags:      []string{"parse-error"},
+	}
+}
+
+// Parse parses b as either an RFC 3164 or RFC 5424 syslog message, whichever
+// it looks like.
+func Parse(b []byte) (Message, error) {
+	rfc, err := syslogparser.DetectRFC(b)
+	if err != nil {
+		return Message{}, err
+	}
+
+	switch rfc {
+	case syslogparser.RFC_5424:
+		return parse5424(b)
+	default:
+		return parse3164(b)
+	}
+}
+
+func priorityTags(dmp syslogparser.LogParts) []string {
+	return []string{
+		fmt.Sprintf("priority-%v", dmp["priority"]),
+		fmt.Sprintf("facility-%v", dmp["facility"]),
+		fmt.Sprintf("severity-%v", dmp["severity"]),
+	}
+}
+
+func parse3164(b []byte) (Message, error) {
+	p := rfc3164.NewParser(b)
+	if err := p.Parse(); err != nil {
+		return Message{}, err
+	}
+
+	dmp := p.Dump()
+
+	tags := priorityTags(dmp)
+	if dmp["tag"] != "" {
+		tags = append(tags, fmt.Sprintf("tag-%v", dmp["tag"]))
+	}
+
+	hostname, _ := dmp["hostname"].(string)
+	return Message{
+		Content:   dmp["content"].(string),
+		Timestamp: dmp["timestamp"].(time.Time),
+		Tags:      tags,
+		Hostname:  hostname,
+	}, nil
+}
+
+func parse5424(b []byte) (Message, error) {
+	p := rfc5424.NewParser(b)
+	if err := p.Parse(); err != nil {
+		return Message{}, err
+	}
+
+	dmp := p.Dump()
+
+	tags := priorityTags(dmp)
+	// "-" is the NILVALUE for each of these
+	for _, f := range []struct{ key, prefix string }{
+		{"app_name", "app"},
+		{"proc_id", "procid"},
+		{"msg_id", "msgid"},
+	} {
+		if v, _ := dmp[f.key].(string); v != "" && v != "-" {
+			tags = append(tags, fmt.Sprintf("%s-%s", f.prefix, v))
+		}
+	}
+
+	if sd, _ := dmp["structured_data"].(string); sd != "" && sd != "-" {
+		sdTags, err := structuredDataTags(sd)
+		if err != nil {
+			return Message{}, err
+		}
+		tags = append(tags, sdTags...)
+	}
+
+	hostname, _ := dmp["hostname"].(string)
+	if hostname == "-" {
+		hostname = ""
+	}
+	return Message{
+		Content:   dmp["message"].(string),
+		Timestamp: dmp["timestamp"].(time.Time),
+		Tags:      tags,
+		Hostname:  hostname,
+	}, nil
+}
+
+// structuredDataTags turns RFC 5424 STRUCTURED-DATA, e.g.
+//
+//	[exampleSDID@32473 iut="3" eventSource="Application"]
+//
+// into tags of the form "sd-exampleSDID@32473.iut-3".
+func structuredDataTags(sd string) ([]string, error) {
+	tags := []string{}
+	for len(sd) > 0 {
+		if sd[0] != '[' {
+			return nil, fmt.Errorf("malformed structured data: expected [ at %q", sd)
+		}
+		sd = sd[1:]
+
+		end := strings.IndexAny(sd, " ]")
+		if end < 0 {
+			return nil, fmt.Errorf("malformed structured data: unterminated element")
+		}
+		id := sd[:end]
+		sd = sd[end:]
+
+		for len(sd) > 0 && sd[0] == ' ' {
+			sd = sd[1:]
+
+			eq := strings.Index(sd, `="`)
+			if eq < 0 {
+				return nil, fmt.Errorf("malformed structured data: expected param in %s", id)
+			}
+			name := sd[:eq]
+			sd = sd[eq+2:]
+
+			// the value runs to the next unescaped quote
+			var value []byte
+			for {
+				if len(sd) == 0 {
+					return nil, fmt.Errorf("malformed structured data: unterminated value for %s.%s", id, name)
+				}
+				c := sd[0]
+				sd = sd[1:]
+				if c == '"' {
+					break
+				}
+				if c == '\\' && len(sd) > 0 && (sd[0] == '"' || sd[0] == '\\' || sd[0] == ']') {
+					c = sd[0]
+					sd = sd[1:]
+				}
+				value = append(value, c)
+			}
+
+			tags = append(tags, fmt.Sprintf("sd-%s.%s-%s", id, name, value))
+		}
+
+		if len(sd) == 0 || sd[0] != ']' {
+			return nil, fmt.Errorf("malformed structured data: unterminated element %s", id)
+		}
+		sd = sd[1:]
+	}
+	return tags, nil
+}
@@ -4,10 +4,24 @@ import (
 	"fmt"
 	"github.com/icphalanx/agent/reporters"
 	"github.com/icphalanx/agent/types"
+	"log"
 	"net"
 	"os"
 )
 
+const (
+	// where journald forwards messages for a traditional syslog daemon
+	DefaultSocketPath = "/run/systemd/journal/syslog"
+
+	// refuse to start if something else is already listening on the socket
+	MODE_COOPERATIVE = "cooperative"
+	// move whatever is listening on the socket aside, and put it back on Close
+	MODE_TAKEOVER = "takeover"
+
+	// where a taken over socket is kept whilst we are listening in its place
+	takenOverSuffix = ".phagent-orig"
+)
+
 func init() {
 	reporters.Register(SyslogSocketReporterFactory{})
 }
@@ -18,8 +32,8 @@ func (SyslogSocketReporterFactory) Id() string {
 	return "syslogsocket"
 }
 
-func (ssrf SyslogSocketReporterFactory) Create(h types.Host) (types.Reporter, error) {
-	relevant, err := ssrf.ApplicableTo(h)
+func (ssrf SyslogSocketReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	relevant, err := ssrf.ApplicableTo(h, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -27,25 +41,104 @@ func (ssrf SyslogSocketReporterFactory) Create(h types.Host) (types.Reporter, er
 		return nil, fmt.Errorf("SyslogSocketReporter not relevant for this system")
 	}
 
-	os.Remove("/run/systemd/journal/syslog")
+	socketPath, err := opts.String("socket_path", DefaultSocketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := opts.String("mode", MODE_COOPERATIVE)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != MODE_COOPERATIVE && mode != MODE_TAKEOVER {
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+
+	var takenOverPath string
 
-	ua, err := net.ResolveUnixAddr("unixgram", "/run/systemd/journal/syslog")
+	// if we crashed whilst we had the socket taken over, its original
+	// listener is still waiting to be handed it back
+	if leftover := socketPath + takenOverSuffix; listenerPresent(leftover) {
+		if listenerPresent(socketPath) {
+			return nil, fmt.Errorf("both %s and %s are in use; move one of them aside", socketPath, leftover)
+		}
+		if mode == MODE_COOPERATIVE {
+			if err := restoreSocket(leftover, socketPath); err != nil {
+				return nil, err
+			}
+		} else {
+			log.Println("syslogsocket: taking", socketPath, "over again from", leftover)
+			takenOverPath = leftover
+		}
+	} else if err := os.Remove(leftover); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if takenOverPath != "" {
+		// what's left at socketPath is our own from before the crash
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			restoreSocket(takenOverPath, socketPath)
+			return nil, err
+		}
+	} else if listenerPresent(socketPath) {
+		if mode == MODE_COOPERATIVE {
+			return nil, fmt.Errorf("%s is already in use by another syslog daemon; configure it to forward to a separate socket_path instead", socketPath)
+		}
+
+		// the existing daemon keeps its socket open, so renaming it back
+		// once we're done hands the path straight back to it
+		log.Println("syslogsocket: taking over", socketPath, "from its existing listener")
+		takenOverPath = socketPath + takenOverSuffix
+		if err := os.Rename(socketPath, takenOverPath); err != nil {
+			return nil, err
+		}
+	} else if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		// anything left at socketPath is stale
+		return nil, err
+	}
+
+	ua, err := net.ResolveUnixAddr("unixgram", socketPath)
 	if err != nil {
+		restoreSocket(takenOverPath, socketPath)
 		return nil, err
 	}
 
 	ln, err := net.ListenUnixgram("unixgram", ua)
 	if err != nil {
+		restoreSocket(takenOverPath, socketPath)
 		return nil, err
 	}
 
 	return &SyslogSocketReporter{
-		host: h,
-		conn: ln,
+		host:          h,
+		conn:          ln,
+		socketPath:    socketPath,
+		takenOverPath: takenOverPath,
 	}, nil
 }
 
-func (SyslogSocketReporterFactory) ApplicableTo(h types.Host) (bool, error) {
+// restoreSocket moves a socket we took over back to path, if there was one.
+func restoreSocket(takenOverPath, path string) error {
+	if takenOverPath == "" {
+		return nil
+	}
+	log.Println("syslogsocket: handing", path, "back to its original listener")
+	return os.Rename(takenOverPath, path)
+}
+
+// listenerPresent reports whether something is already receiving on the
+// datagram socket at path.
+func listenerPresent(path string) bool {
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (SyslogSocketReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
 	// is this a LinuxHost?
 	if !h.IsLocal() {
 		return false, nil
@@ -1,18 +1,21 @@
 package syslogsocket
 
 import (
-	"fmt"
 	"log"
 	"net"
-	"time"
+	"os"
 
+	"github.com/icphalanx/agent/reporters/syslogmsg"
 	"github.com/icphalanx/agent/types"
-	"github.com/jeromer/syslogparser/rfc3164"
 )
 
 type SyslogSocketReporter struct {
 	host types.Host
-	conn net.Conn
+	conn *net.UnixConn
+
+	socketPath string
+	// where the socket we took over from another daemon is parked, if any
+	takenOverPath string
 }
 
 func (SyslogSocketReporter) Id() string {
@@ -33,40 +36,45 @@ func (SyslogSocketReporter) Hosts() ([]types.Host, error) {
 
 func (ssr *SyslogSocketReporter) LogLines() <-chan types.ReporterLogLine {
 	s := make(chan types.ReporterLogLine)
-	buf := make([]byte, 4096)
+	// the largest datagram we can receive
+	buf := make([]byte, 65536)
 	go func() {
 		for {
 			n, err := ssr.conn.Read(buf)
 			if err != nil {
-				log.Println(":(", err)
-				return
-			}
-
-			p := rfc3164.NewParser(buf[:n])
-			if err := p.Parse(); err != nil {
-				log.Println(":((", err)
+				log.Println("syslogsocket: stopping:", err)
 				return
 			}
 
-			dmp := p.Dump()
-
-			tags := make([]string, 3, 4)
-			tags[0] = fmt.Sprintf("priority-%v", dmp["priority"])
-			tags[1] = fmt.Sprintf("facility-%v", dmp["facility"])
-			tags[2] = fmt.Sprintf("severity-%v", dmp["severity"])
-			if dmp["tag"] != "" {
-				tags = append(tags, fmt.Sprintf("tag-%v", dmp["tag"]))
+			msg, err := syslogmsg.Parse(buf[:n])
+			if err != nil {
+				log.Println("syslogsocket: failed to parse message:", err)
+				msg = syslogmsg.Raw(buf[:n])
 			}
 
 			s <- types.ReporterLogLine{
 				Host:      ssr.host,
 				Reporter:  ssr,
-				LogLine:   dmp["content"].(string),
-				Tags:      tags,
-				Timestamp: dmp["timestamp"].(time.Time),
+				LogLine:   msg.Content,
+				Tags:      msg.Tags,
+				Timestamp: msg.Timestamp,
 			}
 		}
 
 	}()
 	return s
 }
+
+// Close stops listening and removes our socket, so that we don't leave a
+// stale socket behind for the next syslog daemon to trip over. If we took the
+// socket over, the original is put back.
+func (ssr *SyslogSocketReporter) Close() error {
+	err := ssr.conn.Close()
+	if rerr := os.Remove(ssr.socketPath); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	if rerr := restoreSocket(ssr.takenOverPath, ssr.socketPath); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package systemd
+
+import (
+	"github.com/godbus/dbus"
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+)
+
+func init() {
+	reporters.Register(SystemdReporterFactory{})
+}
+
+type SystemdReporterFactory struct{}
+
+func (SystemdReporterFactory) Id() string {
+	return "systemd"
+}
+
+func (srf SystemdReporterFactory) Create(h types.Host, opts types.ReporterOptions) (types.Reporter, error) {
+	if at, err := srf.ApplicableTo(h, opts); err != nil {
+		return nil, err
+	} else if !at {
+		return nil, reporters.ErrNotApplicable
+	}
+
+	// how many restarts within restart_window count as a restart loop
+	restartLoopCount, err := opts.Int("restart_loop_count", 3)
+	if err != nil {
+		return nil, err
+	}
+	restartLoopWindow, err := opts.Duration("restart_loop_window", defaultRestartLoopWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	// units whose failure we don't care about, e.g. one-off jobs
+	ignoreUnits, err := opts.Strings("ignore_units", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// we have our own connection so that the stream of PropertiesChanged
+	// signals doesn't get in the way of anyone else on the system bus
+	dbusConn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	if err := dbusConn.Auth(nil); err != nil {
+		dbusConn.Close()
+		return nil, err
+	}
+	if err := dbusConn.Hello(); err != nil {
+		dbusConn.Close()
+		return nil, err
+	}
+
+	sr := &SystemdReporter{
+		dbusConn: dbusConn,
+		dbusObj:  dbusConn.Object(systemdBusName, systemdPath),
+
+		restartLoopCount:  restartLoopCount,
+		restartLoopWindow: restartLoopWindow,
+		ignoreUnits:       map[string]bool{},
+
+		restarts: map[string][]restartSample{},
+		issues:   types.NewIssueTracker(h),
+		changed:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	for _, unit := range ignoreUnits {
+		sr.ignoreUnits[unit] = true
+	}
+
+	if err := sr.subscribe(); err != nil {
+		dbusConn.Close()
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+func (SystemdReporterFactory) ApplicableTo(h types.Host, opts types.ReporterOptions) (bool, error) {
+	// is this a LinuxHost?
+	if !h.IsLocal() {
+		return false, nil
+	}
+
+	// does dbus work?
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false, err
+	}
+
+	// can we communicate with systemd?
+	obj := conn.Object(systemdBusName, systemdPath)
+	_, err = obj.GetProperty(managerInterface + ".Version")
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,36 @@
+package systemd
+
+import (
+	"github.com/icphalanx/agent/types"
+)
+
+type FailedUnitsMetric struct {
+	failedUnits []string
+}
+
+func (FailedUnitsMetric) Id() string {
+	return "failed_units"
+}
+
+func (FailedUnitsMetric) MetricType() types.MetricType {
+	return types.METRICTYPE_STRINGARRAY
+}
+
+func (fum FailedUnitsMetric) Value() []string {
+	return fum.failedUnits
+}
+
+func (fum FailedUnitsMetric) Status() types.MetricStatus {
+	if len(fum.failedUnits) > 0 {
+		return types.METRICSTATUS_WARNING
+	}
+	return types.METRICSTATUS_HEALTHY
+}
+
+func (FailedUnitsMetric) HumanName() string {
+	return "Failed units"
+}
+
+func (FailedUnitsMetric) HumanDesc() string {
+	return "A list of systemd units which are currently in the failed state"
+}
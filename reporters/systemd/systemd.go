@@ -0,0 +1,334 @@
+package systemd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/icphalanx/agent/types"
+)
+
+const (
+	systemdBusName   = "org.freedesktop.systemd1"
+	systemdPath      = dbus.ObjectPath("/org/freedesktop/systemd1")
+	managerInterface = "org.freedesktop.systemd1.Manager"
+	unitInterface    = "org.freedesktop.systemd1.Unit"
+	serviceInterface = "org.freedesktop.systemd1.Service"
+
+	defaultRestartLoopWindow = 10 * time.Minute
+
+	// Issues and Metrics are called back to back on each tick, so we reuse
+	// what we found for a little while rather than asking systemd twice
+	snapshotMaxAge = time.Second
+
+	// how long we wait for a burst of changes to settle before asking for a
+	// report
+	changeSettleTime = time.Second
+)
+
+// unitStatus is what ListUnits returns for each unit.
+type unitStatus struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Followed    string
+	Path        dbus.ObjectPath
+	JobId       uint32
+	JobType     string
+	JobPath     dbus.ObjectPath
+}
+
+type restartSample struct {
+	at        time.Time
+	nRestarts uint32
+}
+
+type snapshot struct {
+	at           time.Time
+	systemState  string
+	failed       []unitStatus
+	restartLoops map[string]int // unit name to restarts within the window
+}
+
+// SystemdReporter reports on the state of systemd and its units.
+type SystemdReporter struct {
+	dbusConn *dbus.Conn
+	dbusObj  dbus.BusObject
+
+	restartLoopCount  int
+	restartLoopWindow time.Duration
+	ignoreUnits       map[string]bool
+
+	mu   sync.Mutex
+	last *snapshot
+	// NRestarts seen for each service within the restart window
+	restarts map[string][]restartSample
+
+	issues *types.IssueTracker
+
+	changed chan struct{}
+	done    chan struct{}
+}
+
+func (*SystemdReporter) Id() string {
+	return "systemd"
+}
+
+func (*SystemdReporter) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (*SystemdReporter) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+// Changed is poked whenever a unit or the system changes state.
+func (sr *SystemdReporter) Changed() <-chan struct{} {
+	return sr.changed
+}
+
+// subscribe asks systemd to tell us about unit state changes, and starts
+// watching for them.
+func (sr *SystemdReporter) subscribe() error {
+	if err := sr.dbusObj.Call(managerInterface+".Subscribe", 0).Err; err != nil {
+		return err
+	}
+
+	matchRule := fmt.Sprintf(
+		"type='signal',sender='%s',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path_namespace='%s'",
+		systemdBusName, systemdPath,
+	)
+	if err := sr.dbusConn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, matchRule).Err; err != nil {
+		return err
+	}
+
+	ch := make(chan *dbus.Signal, 10)
+	sr.dbusConn.Signal(ch)
+	go sr.watch(ch)
+	return nil
+}
+
+// interesting is whether a PropertiesChanged signal is for something we
+// report on.
+func interesting(s *dbus.Signal) bool {
+	if s.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" || len(s.Body) < 2 {
+		return false
+	}
+
+	iface, _ := s.Body[0].(string)
+	changed, _ := s.Body[1].(map[string]dbus.Variant)
+	switch iface {
+	case unitInterface:
+		_, active := changed["ActiveState"]
+		_, sub := changed["SubState"]
+		return active || sub
+	case managerInterface:
+		_, state := changed["SystemState"]
+		return state
+	}
+	return false
+}
+
+func (sr *SystemdReporter) watch(ch <-chan *dbus.Signal) {
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-sr.done:
+			return
+		case s, ok := <-ch:
+			if !ok {
+				return
+			}
+			if interesting(s) && settle == nil {
+				settle = time.After(changeSettleTime)
+			}
+		case <-settle:
+			settle = nil
+
+			// make sure the next report asks systemd afresh
+			sr.mu.Lock()
+			sr.last = nil
+			sr.mu.Unlock()
+
+			select {
+			case sr.changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// restartsWithin records the latest NRestarts for unit, and returns how many
+// times it has restarted within the restart window.
+func (sr *SystemdReporter) restartsWithin(unit string, nRestarts uint32, now time.Time) int {
+	samples := append(sr.restarts[unit], restartSample{now, nRestarts})
+
+	cutoff := now.Add(-sr.restartLoopWindow)
+	for len(samples) > 1 && samples[0].at.Before(cutoff) {
+		samples = samples[1:]
+	}
+	sr.restarts[unit] = samples
+
+	if nRestarts < samples[0].nRestarts {
+		// the counter was reset, e.g. by systemctl reset-failed
+		sr.restarts[unit] = samples[len(samples)-1:]
+		return 0
+	}
+	return int(nRestarts - samples[0].nRestarts)
+}
+
+func (sr *SystemdReporter) refresh() (*snapshot, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	now := time.Now()
+	if sr.last != nil && now.Sub(sr.last.at) < snapshotMaxAge {
+		return sr.last, nil
+	}
+
+	v, err := sr.dbusObj.GetProperty(managerInterface + ".SystemState")
+	if err != nil {
+		return nil, err
+	}
+	systemState, _ := v.Value().(string)
+
+	var units []unitStatus
+	if err := sr.dbusObj.Call(managerInterface+".ListUnits", 0).Store(&units); err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{
+		at:           now,
+		systemState:  systemState,
+		restartLoops: map[string]int{},
+	}
+	seen := map[string]bool{}
+	for _, u := range units {
+		if sr.ignoreUnits[u.Name] {
+			continue
+		}
+		if u.ActiveState == "failed" {
+			snap.failed = append(snap.failed, u)
+		}
+
+		if !strings.HasSuffix(u.Name, ".service") || u.LoadState != "loaded" {
+			continue
+		}
+		seen[u.Name] = true
+
+		// NRestarts only exists from systemd 235
+		v, err := sr.dbusConn.Object(systemdBusName, u.Path).GetProperty(serviceInterface + ".NRestarts")
+		if err != nil {
+			continue
+		}
+		nRestarts, ok := v.Value().(uint32)
+		if !ok {
+			continue
+		}
+		if n := sr.restartsWithin(u.Name, nRestarts, now); n >= sr.restartLoopCount {
+			snap.restartLoops[u.Name] = n
+		}
+	}
+
+	// forget about units which have gone away
+	for unit := range sr.restarts {
+		if !seen[unit] {
+			delete(sr.restarts, unit)
+		}
+	}
+
+	sr.last = snap
+	return snap, nil
+}
+
+func (sr *SystemdReporter) Issues() ([]types.Issue, error) {
+	snap, err := sr.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []types.IssueDetails{}
+
+	switch snap.systemState {
+	case "running":
+	case "degraded":
+		issues = append(issues, types.IssueDetails{
+			Id:          "system-state",
+			Severity:    types.ISSUESEVERITY_WARNING,
+			Title:       "System is degraded",
+			Description: "The system is up, but one or more units have failed.",
+			Remediation: "Run `systemctl --failed` to see which units have failed.",
+		})
+	case "maintenance":
+		issues = append(issues, types.IssueDetails{
+			Id:          "system-state",
+			Severity:    types.ISSUESEVERITY_CRITICAL,
+			Title:       "System is in maintenance mode",
+			Description: "The system is in the rescue or emergency target.",
+			Remediation: "Check the console for why the system failed to boot normally.",
+		})
+	default:
+		// e.g. starting up or shutting down
+		issues = append(issues, types.IssueDetails{
+			Id:          "system-state",
+			Severity:    types.ISSUESEVERITY_INFO,
+			Title:       "System is " + snap.systemState,
+			Description: fmt.Sprintf("The system is in the %q state.", snap.systemState),
+		})
+	}
+
+	for _, u := range snap.failed {
+		issues = append(issues, types.IssueDetails{
+			Id:          "failed:" + u.Name,
+			Severity:    types.ISSUESEVERITY_WARNING,
+			Title:       u.Name + " has failed",
+			Description: fmt.Sprintf("The unit %s (%s) is in the failed state (%s).", u.Name, u.Description, u.SubState),
+			Remediation: fmt.Sprintf("Run `systemctl status %s` and `journalctl -u %s` to find out why. Once fixed, restart it or run `systemctl reset-failed %s`.", u.Name, u.Name, u.Name),
+		})
+	}
+
+	loops := make([]string, 0, len(snap.restartLoops))
+	for unit := range snap.restartLoops {
+		loops = append(loops, unit)
+	}
+	sort.Strings(loops)
+	for _, unit := range loops {
+		issues = append(issues, types.IssueDetails{
+			Id:          "restart-loop:" + unit,
+			Severity:    types.ISSUESEVERITY_WARNING,
+			Title:       unit + " is restarting repeatedly",
+			Description: fmt.Sprintf("%s has been restarted %d times in the last %s.", unit, snap.restartLoops[unit], sr.restartLoopWindow),
+			Remediation: fmt.Sprintf("Run `journalctl -u %s` to find out why it keeps exiting.", unit),
+		})
+	}
+
+	return sr.issues.Issues(issues, time.Now()), nil
+}
+
+func (sr *SystemdReporter) Metrics() ([]types.Metric, error) {
+	snap, err := sr.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make([]string, len(snap.failed))
+	for n, u := range snap.failed {
+		failed[n] = u.Name
+	}
+	return []types.Metric{FailedUnitsMetric{failed}}, nil
+}
+
+// Close stops watching for changes.
+func (sr *SystemdReporter) Close() error {
+	close(sr.done)
+	if err := sr.dbusObj.Call(managerInterface+".Unsubscribe", 0).Err; err != nil {
+		log.Println("systemd: failed to unsubscribe:", err)
+	}
+	return sr.dbusConn.Close()
+}
@@ -1,16 +1,14 @@
 package agent
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
 
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
@@ -18,6 +16,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/icphalanx/agent/spool"
 	"github.com/icphalanx/agent/types"
 	pb "github.com/icphalanx/rpc"
 )
@@ -27,16 +26,65 @@ import (
 )
 
 var (
-	ErrExitingForCertRotation = fmt.Errorf(`exiting to allow certificate rotation`)
+	ErrNotConnected = fmt.Errorf(`not connected to upstream`)
+	ErrClosed       = fmt.Errorf(`closed`)
 )
 
+// how long we give the collector to answer a call before we give up on it
+// and reconnect
+const rpcTimeout = 30 * time.Second
+
+// RPCAgent is a Sink which sends everything to a PhalanxCollector over gRPC.
 type RPCAgent struct {
+	target    string
+	tlsConfig *tls.Config
+
+	connMu sync.RWMutex
 	client pb.PhalanxCollectorClient
-	agent  types.Host
 	conn   *grpc.ClientConn
-	cert   *tls.Certificate
 
-	logLineChan chan types.ReporterLogLine
+	agent types.Host
+
+	cert         atomic.Value // *tls.Certificate
+	certPath     string
+	privKeyPath  string
+	keyAlgorithm KeyAlgorithm
+
+	// the enrolment token to remove once we have our own certificate
+	enrolmentTokenPath string
+
+	*settingsHolder
+
+	state          uint32
+	logStreamState uint32
+	// poked when a Report fails, to wake up the connection loop
+	disconnected chan struct{}
+	lastReport   atomic.Value // *pb.ReportRequest
+
+	logLineChan chan *pb.LogLine
+	spool       *spool.Spool
+
+	// held whilst LogLine decides between logLineChan and the spool
+	logMu sync.Mutex
+	// whether lines are going to the spool rather than logLineChan; only
+	// the log line handler takes lines off logLineChan
+	spooling bool
+	// poked when we start spooling, to wake up the log line handler
+	spooled chan struct{}
+
+	// closed by Close, to stop our goroutines
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (*RPCAgent) Id() string {
+	return "rpc"
+}
+
+// Reporters returns the spool, so that it is reported on alongside the
+// host's own reporters.
+func (r *RPCAgent) Reporters() []types.Reporter {
+	return []types.Reporter{r.spool}
 }
 
 func (r *RPCAgent) init() error {
@@ -47,203 +95,256 @@ func (r *RPCAgent) init() error {
 		return err
 	}
 
-	_, err = r.client.ConfigureMe(context.TODO(), rpcAgent)
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	_, err = r.rpcClient().ConfigureMe(ctx, rpcAgent)
 
 	return err
 }
 
 func (r *RPCAgent) certDueForRenewal() bool {
-	if r.cert.Leaf == nil {
-		var err error
-		r.cert.Leaf, err = x509.ParseCertificate(r.cert.Certificate[0])
-		if err != nil {
-			log.Println("got error whilst checking if cert is due for renewal", err)
-
-			// this will cause getNewCertificateIfNeeded to f
-			// which should hopefully propagate the same error
-			return true
-		}
-	}
-
-	return r.cert.Leaf.NotAfter.Before(time.Now().Add(30 * 24 * time.Hour))
+	settings, _ := r.Settings()
+	return r.currentCert().Leaf.NotAfter.Before(time.Now().Add(settings.RenewalWindow))
 }
 
-func (r *RPCAgent) getNewCertificateIfNeeded(caCertPool *x509.CertPool, certPath, privKeyPath string) (*tls.Config, error) {
-	var err error
-	r.cert.Leaf, err = x509.ParseCertificate(r.cert.Certificate[0])
-	if err != nil {
-		return nil, err
-	}
-
-	if r.cert.Leaf.Subject.CommonName != `Phalanx Provisioning Certificate` || r.certDueForRenewal() {
+func (r *RPCAgent) getNewCertificateIfNeeded() (bool, error) {
+	if r.currentCert().Leaf.Subject.CommonName != ProvisioningCommonName && !r.certDueForRenewal() {
 		// we don't need to get a new certificate
-		return nil, nil
+		return false, nil
 	}
 
-	commonName, err := r.agent.HumanName()
-	if err != nil {
-		return nil, err
+	if err := r.renewCertificate(); err != nil {
+		return false, err
 	}
 
-	// open our file handles
-	certFile, err := os.Create(certPath)
-	if err != nil {
-		return nil, err
+	if r.enrolmentTokenPath != "" {
+		log.Println("provisioning: enrolled, removing enrolment token", r.enrolmentTokenPath)
+		if err := os.Remove(r.enrolmentTokenPath); err != nil {
+			log.Println("provisioning: failed to remove enrolment token:", err)
+		}
 	}
-	defer certFile.Close()
+	return true, nil
+}
 
-	privKeyFile, err := os.Create(privKeyPath)
-	if err != nil {
-		return nil, err
+func recordFromRPC(ll *pb.LogLine) spool.Record {
+	return spool.Record{
+		Host:      ll.Host,
+		Reporter:  ll.Reporter,
+		Line:      ll.Line,
+		Timestamp: types.GoogleTimestampToTime(ll.Timestamp),
+		Tags:      ll.Tags,
 	}
-	defer privKeyFile.Close()
+}
 
-	// we need a new certificate
-	// so... generate a new keypair
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
-	}
-	privAsn := x509.MarshalPKCS1PrivateKey(priv)
-	privPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privAsn,
-	})
-	if privKeyFile.Write(privPem); err != nil {
-		return nil, err
+func recordToRPC(rec spool.Record) *pb.LogLine {
+	return &pb.LogLine{
+		Reporter:  rec.Reporter,
+		Timestamp: types.TimeToGoogleTimestamp(rec.Timestamp),
+		Line:      rec.Line,
+		Host:      rec.Host,
+		Tags:      rec.Tags,
 	}
+}
 
-	csr := x509.CertificateRequest{
-		Subject: pkix.Name{
-			CommonName: commonName,
-		},
-		DNSNames: []string{commonName},
-	}
-	genCsr, err := x509.CreateCertificateRequest(rand.Reader, &csr, priv)
-	if err != nil {
-		return nil, err
-	}
+func (r *RPCAgent) logLineHandler() {
+	log.Println("loglinehandler: starting up")
+	defer r.spool.Close()
 
-	csrPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE REQUEST",
-		Bytes: genCsr,
-	})
+	var (
+		stream pb.PhalanxCollector_RecordLogsClient
+		// a line we took off logLineChan but couldn't send, which is older
+		// than anything else queued or spooled and so is sent first
+		pending *pb.LogLine
+	)
+	bo := newBackoff(time.Second, 5*time.Minute)
+	retry := time.After(0)
 
-	signingResp, err := r.client.SignMe(context.TODO(), &pb.SigningRequest{
-		Csr: string(csrPem),
-	})
-	if err != nil {
-		return nil, err
-	}
+	for {
+		var err error
 
-	if _, err := certFile.WriteString(signingResp.Cert); err != nil {
-		return nil, err
-	}
+		// whilst we're disconnected, lines stay on logLineChan until it fills
+		// up, so that everything on it is always older than the spool
+		queue := r.logLineChan
+		if stream == nil {
+			queue = nil
+		}
 
-	kp, err := tls.X509KeyPair([]byte(signingResp.Cert), privPem)
-	if err != nil {
-		return nil, err
-	}
+		select {
+		case <-r.stop:
+			r.spoolQueued(pending)
+			return
 
-	r.cert = &kp
-	return generateTLSConfig(caCertPool, kp)
-}
+		case ll, ok := <-queue:
+			if !ok {
+				return
+			}
+			if err = stream.Send(ll); err == nil {
+				continue
+			}
+			log.Println("loglinehandler: failed to stream.Send, spooling:", err)
+			pending = ll
 
-func (r *RPCAgent) logLineHandler() {
-	log.Println("loglinehandler: starting up")
-	stream, err := r.client.RecordLogs(context.Background())
-	if err != nil {
-		log.Fatalf("loglinehandler: failed to RecordLogs: %v", err)
+		case <-r.spooled:
+			if stream == nil {
+				// we'll drain the spool once we've reconnected
+				continue
+			}
+			if pending, err = r.flushQueue(stream); err == nil {
+				if err = r.drainSpool(stream); err == nil {
+					continue
+				}
+			}
+			log.Println("loglinehandler: failed to replay spool:", err)
+
+		case <-retry:
+			retry = nil
+
+			stream, err = r.openLogStream()
+			if err == nil && pending != nil {
+				if err = stream.Send(pending); err == nil {
+					pending = nil
+				}
+			}
+			if err == nil {
+				// send what queued up whilst we were disconnected, then
+				// anything spooled after it, so lines stay in order
+				if pending, err = r.flushQueue(stream); err == nil {
+					err = r.drainSpool(stream)
+				}
+			}
+			if err == nil {
+				bo.Reset()
+				continue
+			}
+			log.Println("loglinehandler: failed to open stream:", err)
+		}
+
+		stream = nil
+		r.setLogStreamState(CONNSTATE_DISCONNECTED)
+		wait := bo.Next()
+		log.Println("loglinehandler: reconnecting in", wait)
+		retry = time.After(wait)
 	}
+}
 
+// flushQueue sends whatever is queued on logLineChan down stream. If a send
+// fails, it returns the line it was sending.
+func (r *RPCAgent) flushQueue(stream pb.PhalanxCollector_RecordLogsClient) (*pb.LogLine, error) {
 	for {
-		lc := <-r.logLineChan
-		hn, err := lc.Host.HumanName()
-		if err != nil {
-			log.Println("loglinehandler: failed to get HumanName for %s", lc)
-			continue
-		}
-		if err := stream.Send(&pb.LogLine{
-			Reporter:  lc.Reporter.Id(),
-			Timestamp: types.TimeToGoogleTimestamp(lc.Timestamp),
-			Line:      lc.LogLine,
-			Host:      hn,
-			Tags:      lc.Tags,
-		}); err != nil {
-			log.Fatalf("loglinehandler: failed to stream.Send: %v", err)
+		select {
+		case ll, ok := <-r.logLineChan:
+			if !ok {
+				return nil, nil
+			}
+			if err := stream.Send(ll); err != nil {
+				return ll, err
+			}
+		default:
+			return nil, nil
 		}
 	}
 }
 
-func (r *RPCAgent) Run() error {
-	// do a first run
-	err := r.tick()
-	if err != nil {
-		return err
-	}
+// spoolQueued keeps the lines we haven't sent when we're stopped, starting
+// with pending. They are older than anything already spooled, so if there is
+// anything they will be replayed out of order after a restart; that's better
+// than losing them.
+func (r *RPCAgent) spoolQueued(pending *pb.LogLine) {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
 
-	exitCh := make(chan struct{})
-	go func(exitCh chan<- struct{}) {
-		sleepFor := r.cert.Leaf.NotAfter.Sub(time.Now()) - (20 * 24 * time.Hour)
-		log.Println("certrenew: sleeping for", sleepFor)
-		time.Sleep(sleepFor)
-		log.Println("certrenew: woke up!")
-		exitCh <- struct{}{}
-	}(exitCh)
+	lines := []*pb.LogLine{}
+	if pending != nil {
+		lines = append(lines, pending)
+	}
+	for ll := range r.logLineChan {
+		lines = append(lines, ll)
+	}
+	for _, ll := range lines {
+		if err := r.spool.Append(recordFromRPC(ll)); err != nil {
+			log.Println("loglinehandler: failed to spool line, dropping it:", err)
+		}
+	}
+}
 
-	// log line chan
-	go r.logLineHandler()
-	// and spin up handlers
-	reporters, err := r.agent.Reporters()
-	if err != nil {
-		return err
+// startSpooling sends every line from now on through the spool, until
+// drainSpool has emptied it. Anything still queued on logLineChan is older,
+// so the log line handler sends that first. r.logMu must be held.
+func (r *RPCAgent) startSpooling() {
+	if r.spooling {
+		return
 	}
-	for _, reporter := range reporters {
-		go func(reporter types.Reporter) {
-			llc := reporter.LogLines()
-			for {
-				r.logLineChan <- <-llc
-			}
-		}(reporter)
+	r.spooling = true
+
+	select {
+	case r.spooled <- struct{}{}:
+	default:
 	}
+}
 
-	ticker := time.NewTicker(60 * time.Second)
+// drainSpool replays the spool down stream until it is empty, and then lets
+// LogLine go back to queueing lines on logLineChan.
+func (r *RPCAgent) drainSpool(stream pb.PhalanxCollector_RecordLogsClient) error {
 	for {
-		select {
-		case <-exitCh:
-			close(r.logLineChan)
-			return ErrExitingForCertRotation
-		case <-ticker.C:
-			err := r.tick()
-			if err != nil {
-				close(r.logLineChan)
-				return err
-			}
+		if err := r.replaySpool(stream); err != nil {
+			return err
+		}
+
+		r.logMu.Lock()
+		if r.spool.Len() == 0 {
+			r.spooling = false
+			r.logMu.Unlock()
+			return nil
 		}
+		r.logMu.Unlock()
 	}
 }
 
-func (r *RPCAgent) tick() error {
-	var err error
-	log.Println("tick...")
-	rep := new(pb.ReportRequest)
-
-	rep.Host, err = types.HostToRPC(r.agent)
-	if err != nil {
-		return err
+// replaySpool sends everything in the spool down stream, oldest first.
+func (r *RPCAgent) replaySpool(stream pb.PhalanxCollector_RecordLogsClient) error {
+	if r.spool.Len() == 0 {
+		return nil
 	}
 
-	reporters, err := r.agent.Reporters()
-	if err != nil {
-		return err
+	log.Println("loglinehandler: replaying", r.spool.Len(), "spooled lines")
+	return r.spool.Replay(func(rec spool.Record) error {
+		return stream.Send(recordToRPC(rec))
+	})
+}
+
+func (r *RPCAgent) Start() error {
+	go r.certRotator()
+	go r.logLineHandler()
+	go r.connectionLoop()
+	return nil
+}
+
+// Report sends rep upstream if we are connected. If we aren't, the most
+// recent report is sent as soon as we reconnect.
+func (r *RPCAgent) Report(rep *pb.ReportRequest) error {
+	r.lastReport.Store(rep)
+
+	if r.State() != CONNSTATE_READY {
+		return ErrNotConnected
 	}
 
-	rep.Reporters, err = types.ReportersToRPC(reporters)
-	if err != nil {
+	if err := r.report(rep); err != nil {
+		r.setState(CONNSTATE_DISCONNECTED)
+		select {
+		case r.disconnected <- struct{}{}:
+		default:
+		}
 		return err
 	}
+	return nil
+}
 
-	resp, err := r.client.Report(context.TODO(), rep)
+func (r *RPCAgent) report(rep *pb.ReportRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	resp, err := r.rpcClient().Report(ctx, rep)
 	if err != nil {
 		return err
 	}
@@ -254,6 +355,40 @@ func (r *RPCAgent) tick() error {
 	return nil
 }
 
+// LogLine queues ll to be streamed upstream. If the queue is full, because
+// the stream is slow or down, ll is spooled to disk rather than holding up the
+// other sinks, and so is every line after it until the spool has been
+// replayed, so that lines are still sent in order.
+func (r *RPCAgent) LogLine(ll *pb.LogLine) error {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+
+	if !r.spooling {
+		select {
+		case r.logLineChan <- ll:
+			return nil
+		default:
+			r.startSpooling()
+		}
+	}
+	return r.spool.Append(recordFromRPC(ll))
+}
+
+// Close stops streaming log lines and reconnecting, and closes our
+// connection to the collector.
+func (r *RPCAgent) Close() error {
+	var err error
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		close(r.logLineChan)
+
+		r.connMu.Lock()
+		defer r.connMu.Unlock()
+		err = r.conn.Close()
+	})
+	return err
+}
+
 func generateCertPoolFromPath(caPath string) (*x509.CertPool, error) {
 	b, err := ioutil.ReadFile(caPath)
 	if err != nil {
@@ -267,83 +402,99 @@ func generateCertPoolFromPath(caPath string) (*x509.CertPool, error) {
 	return cp, nil
 }
 
-func generateTLSConfig(caCertPool *x509.CertPool, cert tls.Certificate) (*tls.Config, error) {
-	c := new(tls.Config)
-	c.RootCAs = caCertPool
-	c.Certificates = []tls.Certificate{cert}
-	return c, nil
+func (r *RPCAgent) dial() (*grpc.ClientConn, error) {
+	return grpc.Dial(r.target, grpc.WithTransportCredentials(credentials.NewTLS(r.tlsConfig)))
 }
 
-func rpcAgentWithConfig(target string, agent types.Host, tlsConfig *tls.Config) (*RPCAgent, error) {
-	cert := tlsConfig.Certificates[0]
-	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
-	if err != nil {
+func rpcAgentWithConfig(opts Options, agent types.Host, caCertPool *x509.CertPool, cert tls.Certificate, sp *spool.Spool) (*RPCAgent, error) {
+	r := &RPCAgent{
+		target:         opts.Target,
+		agent:          agent,
+		certPath:       opts.CertPath,
+		privKeyPath:    opts.PrivKeyPath,
+		keyAlgorithm:   opts.KeyAlgorithm,
+		settingsHolder: newSettingsHolder(opts.Settings),
+		disconnected:   make(chan struct{}, 1),
+		logLineChan:    make(chan *pb.LogLine, 10),
+		spool:          sp,
+		// anything left from last time has to go before any new lines
+		spooling: sp.Len() > 0,
+		spooled:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	if err := r.setCert(&cert); err != nil {
 		return nil, err
 	}
 
-	client := pb.NewPhalanxCollectorClient(conn)
-	r := RPCAgent{
-		agent:       agent,
-		client:      client,
-		conn:        conn,
-		cert:        &cert,
-		logLineChan: make(chan types.ReporterLogLine, 10),
+	r.tlsConfig = &tls.Config{
+		RootCAs:              caCertPool,
+		GetClientCertificate: r.getClientCertificate,
+	}
+
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
 	}
+	r.conn = conn
+	r.client = pb.NewPhalanxCollectorClient(conn)
 
-	return &r, nil
+	return r, nil
 }
 
-func NewRPCAgent(target string, caPath, certPath, privKeyPath string) (*RPCAgent, error) {
-	// if caPath doesn't exist, abort
-	caCertPool, err := generateCertPoolFromPath(caPath)
+// NewRPCAgent creates a sink reporting on agent to the collector described by
+// opts, enrolling with the collector first if we need a certificate.
+func NewRPCAgent(opts Options, agent types.Host) (*RPCAgent, error) {
+	// if CAPath doesn't exist, abort
+	caCertPool, err := generateCertPoolFromPath(opts.CAPath)
 	if err != nil {
 		return nil, err
 	}
 
 	// load the certificate
-	var cert tls.Certificate
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		// we need to get the provisioning certificate from the binary
-		cert, err = LoadEmbeddedCertPair()
-	} else {
-		cert, err = tls.LoadX509KeyPair(certPath, privKeyPath)
-	}
-	if err != nil {
+	var (
+		cert      tls.Certificate
+		tokenPath string
+	)
+	if err := recoverCertificate(opts.CertPath, opts.PrivKeyPath); err != nil {
 		return nil, err
 	}
-
-	// make the TLS config
-	tlsConfig, err := generateTLSConfig(caCertPool, cert)
+	if _, err = os.Stat(opts.CertPath); os.IsNotExist(err) {
+		// we haven't enrolled yet, so we need a provisioning certificate
+		cert, tokenPath, err = opts.Provisioning.Load()
+	} else {
+		cert, err = tls.LoadX509KeyPair(opts.CertPath, opts.PrivKeyPath)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	agent, err := MakeLocalAgent()
+	sp, err := spool.Open(opts.SpoolPath, spool.DefaultConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := rpcAgentWithConfig(target, agent, tlsConfig)
+	r, err := rpcAgentWithConfig(opts, agent, caCertPool, cert, sp)
 	if err != nil {
 		return nil, err
 	}
+	r.enrolmentTokenPath = tokenPath
 
-	tlsConfig, err = r.getNewCertificateIfNeeded(caCertPool, certPath, privKeyPath)
+	renewed, err := r.getNewCertificateIfNeeded()
 	if err != nil {
 		return nil, err
 	}
 
-	if tlsConfig != nil {
-		// OK, swap out the cert for the new ones we just wrote
-		if err := r.conn.Close(); err != nil {
-			return nil, err
-		}
-
-		r, err = rpcAgentWithConfig(target, agent, tlsConfig)
+	if renewed {
+		// the collector identifies us by the certificate we presented when
+		// connecting, so start afresh with the one we just got
+		conn, err := r.dial()
 		if err != nil {
 			return nil, err
 		}
+		r.conn.Close()
+		r.conn = conn
+		r.client = pb.NewPhalanxCollectorClient(conn)
 	}
 
-	return r, r.init()
+	return r, nil
 }
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/icphalanx/agent/types"
+	pb "github.com/icphalanx/rpc"
+)
+
+// logRecorder keeps the log lines sent to a fakeCollector.
+type logRecorder struct {
+	// how long to take over each line, in nanoseconds
+	delay int64
+
+	mu    sync.Mutex
+	lines []string
+}
+
+var testLogs logRecorder
+
+func (fc *fakeCollector) RecordLogs(stream pb.PhalanxCollector_RecordLogsServer) error {
+	for {
+		ll, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.LogResponse{})
+		} else if err != nil {
+			return err
+		}
+
+		time.Sleep(time.Duration(atomic.LoadInt64(&testLogs.delay)))
+		testLogs.mu.Lock()
+		testLogs.lines = append(testLogs.lines, ll.Line)
+		testLogs.mu.Unlock()
+	}
+}
+
+func (fc *fakeCollector) ConfigureMe(ctx context.Context, h *pb.Host) (*pb.ConfigureResponse, error) {
+	return &pb.ConfigureResponse{}, nil
+}
+
+func (fc *fakeCollector) Report(ctx context.Context, rep *pb.ReportRequest) (*pb.ReportResponse, error) {
+	return &pb.ReportResponse{Success: true}, nil
+}
+
+func (lr *logRecorder) reset() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.lines = nil
+}
+
+func (lr *logRecorder) received() []string {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return append([]string{}, lr.lines...)
+}
+
+func TestLogLinesStayInOrderWhenSpooled(t *testing.T) {
+	te := newTestEnv(t, SIGN_GOOD, ownCert(t, 365*24*time.Hour))
+	testLogs.reset()
+
+	// slow enough that logLineChan fills up, so most lines go via the spool
+	atomic.StoreInt64(&testLogs.delay, int64(time.Millisecond))
+	defer atomic.StoreInt64(&testLogs.delay, 0)
+
+	go te.agent.logLineHandler()
+	defer te.agent.Close()
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		if err := te.agent.LogLine(&pb.LogLine{
+			Line:      strconv.Itoa(i),
+			Timestamp: types.TimeToGoogleTimestamp(time.Now()),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	var lines []string
+	for {
+		if lines = testLogs.received(); len(lines) >= n || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(lines) != n {
+		t.Fatalf("collector received %d lines, want %d", len(lines), n)
+	}
+	for i, line := range lines {
+		if line != strconv.Itoa(i) {
+			t.Fatalf("line %d is %q, want lines in the order they were logged", i, line)
+		}
+	}
+	if te.agent.spool.Len() != 0 {
+		t.Errorf("spool still holds %d lines", te.agent.spool.Len())
+	}
+}
+
+func TestCloseStopsAgent(t *testing.T) {
+	te := newTestEnv(t, SIGN_GOOD, ownCert(t, 365*24*time.Hour))
+
+	if err := te.agent.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for te.agent.State() != CONNSTATE_READY {
+		if time.Now().After(deadline) {
+			t.Fatal("never connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := te.agent.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if state := te.agent.conn.GetState(); state != connectivity.Shutdown {
+		t.Errorf("connection is %v after Close, want %v", state, connectivity.Shutdown)
+	}
+	if err := te.agent.redial(); err != ErrClosed {
+		t.Errorf("redial after Close returned %v, want %v", err, ErrClosed)
+	}
+
+	// closing again is harmless
+	if err := te.agent.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
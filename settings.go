@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/config"
+)
+
+// Settings are the parts of the agent's configuration which can be changed
+// whilst it is running.
+type Settings struct {
+	// how often we send a report upstream
+	TickInterval time.Duration
+	// we re-enrol at startup if our certificate expires within this window
+	RenewalWindow time.Duration
+	// we rotate our certificate in the background once it expires within
+	// this window
+	RotationWindow time.Duration
+}
+
+// SettingsFromConfig picks the agent's settings out of c.
+func SettingsFromConfig(c *config.Config) Settings {
+	return Settings{
+		TickInterval:   time.Duration(c.TickInterval),
+		RenewalWindow:  time.Duration(c.RenewalWindow),
+		RotationWindow: time.Duration(c.RotationWindow),
+	}
+}
+
+var DefaultSettings = SettingsFromConfig(config.Default())
+
+// Options configure a new RPCAgent sink.
+type Options struct {
+	Target string
+
+	CAPath      string
+	CertPath    string
+	PrivKeyPath string
+	SpoolPath   string
+
+	KeyAlgorithm KeyAlgorithm
+	Provisioning ProvisioningSource
+
+	Settings Settings
+}
+
+// settingsHolder is embedded in each agent to let its settings be changed
+// whilst it is running.
+type settingsHolder struct {
+	mu       sync.Mutex
+	settings Settings
+	changed  chan struct{}
+}
+
+func newSettingsHolder(s Settings) *settingsHolder {
+	return &settingsHolder{
+		settings: s,
+		changed:  make(chan struct{}),
+	}
+}
+
+// Settings returns the current settings, along with a channel which is closed
+// when they next change.
+func (sh *settingsHolder) Settings() (Settings, <-chan struct{}) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.settings, sh.changed
+}
+
+// UpdateSettings replaces the agent's settings without interrupting its
+// connection or log stream.
+func (sh *settingsHolder) UpdateSettings(s Settings) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.settings = s
+	close(sh.changed)
+	sh.changed = make(chan struct{})
+}
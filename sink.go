@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/icphalanx/agent/reporters"
+	"github.com/icphalanx/agent/types"
+	pb "github.com/icphalanx/rpc"
+)
+
+// A Sink is somewhere the agent sends what its reporters find: a snapshot
+// report on every tick, and a stream of log lines.
+type Sink interface {
+	Id() string
+
+	// Start is called once, before anything is sent to the sink.
+	Start() error
+
+	// Report is called with a snapshot of the host on every tick.
+	Report(*pb.ReportRequest) error
+
+	// LogLine is called for every log line. It is called from the same
+	// goroutine as Report, so should not block for long.
+	LogLine(*pb.LogLine) error
+
+	Close() error
+}
+
+// sinkWithReporters is implemented by sinks which want to report on
+// themselves alongside the host's own reporters.
+type sinkWithReporters interface {
+	Reporters() []types.Reporter
+}
+
+// sinkWithSettings is implemented by sinks which care about Settings.
+type sinkWithSettings interface {
+	UpdateSettings(Settings)
+}
+
+// hostWithReload is implemented by hosts which can re-create their reporters
+// to match a new Selection.
+type hostWithReload interface {
+	Reload(reporters.Selection) ([]types.Reporter, error)
+}
+
+// Agent runs the reporters on a host and fans out what they find to one or
+// more sinks.
+type Agent struct {
+	*settingsHolder
+
+	host  types.Host
+	sinks []Sink
+
+	logLineChan chan types.ReporterLogLine
+	changed     chan struct{}
+	reload      chan reporters.Selection
+
+	lastMu sync.Mutex
+	last   *types.Snapshot
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewAgent(host types.Host, settings Settings, sinks ...Sink) *Agent {
+	return &Agent{
+		settingsHolder: newSettingsHolder(settings),
+		host:           host,
+		sinks:          sinks,
+		logLineChan:    make(chan types.ReporterLogLine, 10),
+		changed:        make(chan struct{}, 1),
+		reload:         make(chan reporters.Selection),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Stop makes Run close its sinks and return.
+func (a *Agent) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+}
+
+// Host returns the host this agent is reporting on.
+func (a *Agent) Host() types.Host {
+	return a.host
+}
+
+// LastSnapshot returns what the reporters returned on the last tick, or nil
+// if there hasn't been one yet.
+func (a *Agent) LastSnapshot() *types.Snapshot {
+	a.lastMu.Lock()
+	defer a.lastMu.Unlock()
+	return a.last
+}
+
+// UpdateSettings replaces the settings of the agent and all of its sinks.
+func (a *Agent) UpdateSettings(s Settings) {
+	a.settingsHolder.UpdateSettings(s)
+	for _, sink := range a.sinks {
+		if sws, ok := sink.(sinkWithSettings); ok {
+			sws.UpdateSettings(s)
+		}
+	}
+}
+
+// ReloadReporters re-creates the host's reporters to match sel, between
+// ticks so that no reporter is closed whilst it is being asked for a report.
+func (a *Agent) ReloadReporters(sel reporters.Selection) {
+	select {
+	case a.reload <- sel:
+	case <-a.stop:
+	}
+}
+
+func (a *Agent) reloadReporters(sel reporters.Selection) {
+	hwr, ok := a.host.(hostWithReload)
+	if !ok {
+		log.Println("reload:", a.host.Id(), "can't reload its reporters; they will only change after a restart")
+		return
+	}
+
+	created, err := hwr.Reload(sel)
+	if err != nil {
+		log.Println("reload: failed to reload reporters:", err)
+		return
+	}
+	forwardReporterLogLines(created, a.logLineChan)
+	forwardReporterChanges(created, a.changed)
+
+	// report straight away, so that the collector sees the change
+	a.tick()
+}
+
+func (a *Agent) tick() {
+	log.Println("tick...")
+
+	extra := []types.Reporter{}
+	for _, sink := range a.sinks {
+		if swr, ok := sink.(sinkWithReporters); ok {
+			extra = append(extra, swr.Reporters()...)
+		}
+	}
+
+	snap, rep, err := buildReport(a.host, extra...)
+	if err != nil {
+		log.Println("failed to build report:", err)
+		return
+	}
+
+	a.lastMu.Lock()
+	a.last = snap
+	a.lastMu.Unlock()
+
+	for _, sink := range a.sinks {
+		if err := sink.Report(rep); err != nil {
+			log.Println(sink.Id(), "failed to report:", err)
+		}
+	}
+}
+
+func (a *Agent) logLine(lc types.ReporterLogLine) {
+	ll, err := types.LogLineToRPC(lc)
+	if err != nil {
+		log.Printf("failed to get HumanName for %v: %v", lc, err)
+		return
+	}
+
+	for _, sink := range a.sinks {
+		if err := sink.LogLine(ll); err != nil {
+			log.Println(sink.Id(), "failed to send log line:", err)
+		}
+	}
+}
+
+func (a *Agent) Run() error {
+	for _, sink := range a.sinks {
+		if err := sink.Start(); err != nil {
+			return err
+		}
+		defer sink.Close()
+	}
+
+	if err := forwardLogLines(a.host, a.logLineChan); err != nil {
+		return err
+	}
+	if err := forwardChanges(a.host, a.changed); err != nil {
+		return err
+	}
+
+	a.tick()
+
+	settings, settingsChanged := a.Settings()
+	ticker := time.NewTicker(settings.TickInterval)
+	for {
+		select {
+		case <-a.stop:
+			ticker.Stop()
+			return nil
+		case <-settingsChanged:
+			settings, settingsChanged = a.Settings()
+			log.Println("settings changed, now reporting every", settings.TickInterval)
+			ticker.Stop()
+			ticker = time.NewTicker(settings.TickInterval)
+		case lc := <-a.logLineChan:
+			a.logLine(lc)
+		case <-ticker.C:
+			a.tick()
+		case <-a.changed:
+			a.tick()
+		case sel := <-a.reload:
+			a.reloadReporters(sel)
+		}
+	}
+}
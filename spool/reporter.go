@@ -0,0 +1,73 @@
+package spool
+
+import (
+	"github.com/icphalanx/agent/types"
+)
+
+// The spool reports on itself as a reporter so that its depth and drop count
+// make their way upstream alongside everything else.
+
+func (*Spool) Id() string {
+	return "spool"
+}
+
+func (*Spool) Issues() ([]types.Issue, error) {
+	return []types.Issue{}, nil
+}
+
+func (s *Spool) Metrics() ([]types.Metric, error) {
+	return []types.Metric{
+		CountMetric{
+			id:        "depth",
+			humanName: "Spooled log lines",
+			humanDesc: "The number of log lines buffered on disk waiting to be sent to the collector.",
+			count:     s.Len(),
+		},
+		CountMetric{
+			id:        "dropped",
+			humanName: "Dropped log lines",
+			humanDesc: "The number of log lines discarded from the on-disk spool since the agent started, either because the spool was full or because they were corrupt.",
+			count:     s.Dropped(),
+		},
+	}, nil
+}
+
+func (*Spool) Hosts() ([]types.Host, error) {
+	return []types.Host{}, nil
+}
+
+func (*Spool) LogLines() <-chan types.ReporterLogLine {
+	return nil
+}
+
+type CountMetric struct {
+	id        string
+	humanName string
+	humanDesc string
+
+	count int
+}
+
+func (cm CountMetric) Id() string {
+	return cm.id
+}
+
+func (CountMetric) MetricType() types.MetricType {
+	return types.METRICTYPE_UNCOUNTABLE
+}
+
+func (cm CountMetric) Value() int {
+	return cm.count
+}
+
+func (CountMetric) Status() types.MetricStatus {
+	return types.METRICSTATUS_NONE
+}
+
+func (cm CountMetric) HumanName() string {
+	return cm.humanName
+}
+
+func (cm CountMetric) HumanDesc() string {
+	return cm.humanDesc
+}
@@ -0,0 +1,423 @@
+// Package spool implements a bounded, on-disk queue of log lines which is
+// used to buffer lines whilst the upstream collector is unreachable.
+//
+// Records are appended as JSON lines to numbered segment files. A segment is
+// never appended to after the spool is reopened, so a torn write from a crash
+// only ever affects the tail of a segment which is no longer being written.
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const segmentSuffix = ".seg"
+
+// Record is a single spooled log line.
+type Record struct {
+	Host      string    `json:"host"`
+	Reporter  string    `json:"reporter"`
+	Line      string    `json:"line"`
+	Timestamp time.Time `json:"timestamp"`
+	Tags      []string  `json:"tags,omitempty"`
+}
+
+type Config struct {
+	// a new segment is started once the current one reaches this size...
+	MaxSegmentBytes int64
+	// ...or this age
+	MaxSegmentAge time.Duration
+
+	// the oldest segments are dropped once the spool exceeds this size...
+	MaxTotalBytes int64
+	// ...or once they were last written longer ago than this
+	MaxAge time.Duration
+}
+
+var DefaultConfig = Config{
+	MaxSegmentBytes: 4 << 20,
+	MaxSegmentAge:   time.Hour,
+	MaxTotalBytes:   256 << 20,
+	MaxAge:          7 * 24 * time.Hour,
+}
+
+type segment struct {
+	seq      uint64
+	path     string
+	size     int64
+	records  int64
+	created  time.Time
+	modified time.Time
+}
+
+type Spool struct {
+	dir string
+	cfg Config
+
+	// held for the whole of a Replay, so that only one runs at once
+	replayMu sync.Mutex
+
+	mu       sync.Mutex
+	segments []*segment // oldest first
+	cur      *os.File   // open handle on the last segment, if we are writing to it
+	readOff  int64      // bytes of segments[0] already replayed
+	readRecs int64      // records of segments[0] already replayed
+
+	depth   int64
+	dropped int64
+}
+
+// Open opens (creating if necessary) the spool stored in dir.
+func Open(dir string, cfg Config) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir: dir,
+		cfg: cfg,
+	}
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			log.Println("spool: ignoring unexpected file", fi.Name())
+			continue
+		}
+
+		seg := &segment{
+			seq:      seq,
+			path:     filepath.Join(dir, fi.Name()),
+			size:     fi.Size(),
+			created:  fi.ModTime(),
+			modified: fi.ModTime(),
+		}
+		if seg.records, err = countRecords(seg.path); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.depth += seg.records
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+
+	return s, nil
+}
+
+func countRecords(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int64
+	br := bufio.NewReader(f)
+	for {
+		_, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+func (s *Spool) totalBytes() int64 {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// dropOldest removes the oldest segment, accounting for any records in it
+// which were never replayed.
+func (s *Spool) dropOldest() error {
+	seg := s.segments[0]
+	lost := seg.records - s.readRecs
+	if s.cur != nil && len(s.segments) == 1 {
+		s.cur.Close()
+		s.cur = nil
+	}
+
+	s.segments = s.segments[1:]
+	s.readOff, s.readRecs = 0, 0
+	atomic.AddInt64(&s.depth, -lost)
+	atomic.AddInt64(&s.dropped, lost)
+	if lost > 0 {
+		log.Printf("spool: dropped %d unsent lines from %s", lost, seg.path)
+	}
+
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Spool) enforceLimits() error {
+	if s.cfg.MaxAge > 0 {
+		cutoff := time.Now().Add(-s.cfg.MaxAge)
+		for len(s.segments) > 0 && s.segments[0].modified.Before(cutoff) {
+			if err := s.dropOldest(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.cfg.MaxTotalBytes > 0 {
+		for len(s.segments) > 0 && s.totalBytes() > s.cfg.MaxTotalBytes {
+			if err := s.dropOldest(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Spool) rotate() error {
+	if s.cur != nil {
+		if err := s.cur.Close(); err != nil {
+			return err
+		}
+		s.cur = nil
+	}
+
+	var seq uint64
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+
+	path := s.segmentPath(seq)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.cur = f
+	s.segments = append(s.segments, &segment{
+		seq:      seq,
+		path:     path,
+		created:  now,
+		modified: now,
+	})
+	return nil
+}
+
+// Append durably writes rec to the end of the spool.
+func (s *Spool) Append(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enforceLimits(); err != nil {
+		return err
+	}
+
+	if s.cur == nil {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	} else {
+		seg := s.segments[len(s.segments)-1]
+		if (s.cfg.MaxSegmentBytes > 0 && seg.size+int64(len(b)) > s.cfg.MaxSegmentBytes) ||
+			(s.cfg.MaxSegmentAge > 0 && time.Since(seg.created) > s.cfg.MaxSegmentAge) {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	n, err := s.cur.Write(b)
+	seg.size += int64(n)
+	if err != nil {
+		// don't risk appending after a torn write
+		s.cur.Close()
+		s.cur = nil
+		return err
+	}
+	if err := s.cur.Sync(); err != nil {
+		return err
+	}
+
+	seg.records++
+	seg.modified = time.Now()
+	atomic.AddInt64(&s.depth, 1)
+	return nil
+}
+
+// Replay calls fn with each spooled record, oldest first. Records are removed
+// from the spool once fn has returned nil for them. If fn returns an error,
+// replay stops and the record will be offered again on the next call.
+//
+// fn is called without holding the spool's lock, so records can be appended
+// whilst a replay is in progress; they are replayed too before Replay
+// returns.
+//
+// Delivery is at-least-once: if the agent crashes part way through a segment,
+// the replayed prefix of that segment will be offered again.
+func (s *Spool) Replay(fn func(Record) error) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		seg := s.segments[0]
+		off, size := s.readOff, seg.size
+		s.mu.Unlock()
+
+		if off < size {
+			if err := s.replaySegment(seg, off, size, fn); err != nil {
+				return err
+			}
+			// more may have been appended to seg in the meantime
+			continue
+		}
+
+		if err := s.removeReplayed(seg); err != nil {
+			return err
+		}
+	}
+}
+
+// removeReplayed removes seg once everything in it has been delivered,
+// unless it has been written to since.
+func (s *Spool) removeReplayed(seg *segment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 || s.segments[0] != seg || s.readOff < seg.size {
+		return nil
+	}
+
+	if s.cur != nil && len(s.segments) == 1 {
+		if err := s.cur.Close(); err != nil {
+			return err
+		}
+		s.cur = nil
+	}
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.segments = s.segments[1:]
+	s.readOff, s.readRecs = 0, 0
+	return nil
+}
+
+// replaySegment replays the records of seg between off and size, which is
+// how much of it had been written when we started.
+func (s *Spool) replaySegment(seg *segment, off, size int64, fn func(Record) error) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(io.LimitReader(f, size-off))
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(b) > 0 {
+				log.Printf("spool: discarding %d bytes of torn write at end of %s", len(b), seg.path)
+			}
+			// skip over whatever is left, so that we don't keep retrying it
+			s.advance(seg, size-off, 0)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var rec Record
+		if err := json.Unmarshal(b, &rec); err != nil {
+			log.Printf("spool: discarding corrupt record in %s: %v", seg.path, err)
+			atomic.AddInt64(&s.dropped, 1)
+		} else if err := fn(rec); err != nil {
+			return err
+		}
+
+		off += int64(len(b))
+		if !s.advance(seg, int64(len(b)), 1) {
+			// seg was dropped to keep the spool within its limits
+			return nil
+		}
+	}
+}
+
+// advance records that n bytes holding recs records of seg have been
+// replayed. It returns false if seg is no longer the oldest segment.
+func (s *Spool) advance(seg *segment, n, recs int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 || s.segments[0] != seg {
+		return false
+	}
+	s.readOff += n
+	s.readRecs += recs
+	atomic.AddInt64(&s.depth, -recs)
+	return true
+}
+
+// Len returns the number of records waiting to be replayed.
+func (s *Spool) Len() int {
+	return int(atomic.LoadInt64(&s.depth))
+}
+
+// Dropped returns the number of records which have been discarded, either
+// because the spool exceeded its limits or because they were corrupt.
+func (s *Spool) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	return err
+}
@@ -0,0 +1,255 @@
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func record(i int) Record {
+	return Record{
+		Host:      "host",
+		Reporter:  "test",
+		Line:      strconv.Itoa(i),
+		Timestamp: time.Unix(int64(i), 0).UTC(),
+	}
+}
+
+func appendN(t *testing.T, s *Spool, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := s.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// replayAll replays everything in s, returning the lines in the order they
+// were offered.
+func replayAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var lines []string
+	if err := s.Replay(func(rec Record) error {
+		lines = append(lines, rec.Line)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func wantLines(t *testing.T, got []string, from, to int) {
+	t.Helper()
+	if len(got) != to-from {
+		t.Fatalf("got %d lines, want %d", len(got), to-from)
+	}
+	for i, line := range got {
+		if want := strconv.Itoa(from + i); line != want {
+			t.Fatalf("line %d is %q, want %q", i, line, want)
+		}
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Config{MaxSegmentBytes: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 0, 20)
+	if n := len(segmentFiles(t, dir)); n < 2 {
+		t.Fatalf("found %d segments, want the spool to have rolled over", n)
+	}
+	for _, path := range segmentFiles(t, dir) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 300 {
+			t.Errorf("%s is %d bytes, over the 300 byte limit", path, fi.Size())
+		}
+	}
+	if s.Len() != 20 {
+		t.Errorf("Len is %d, want 20", s.Len())
+	}
+
+	wantLines(t, replayAll(t, s), 0, 20)
+	if n := len(segmentFiles(t, dir)); n != 0 {
+		t.Errorf("found %d segments after replaying everything, want none", n)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len is %d after replaying everything, want 0", s.Len())
+	}
+}
+
+func TestSegmentAgeRollover(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Config{MaxSegmentAge: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 0, 5)
+	s.segments[0].created = time.Now().Add(-2 * time.Minute)
+	appendN(t, s, 5, 10)
+
+	if n := len(segmentFiles(t, dir)); n != 2 {
+		t.Errorf("found %d segments, want a new one once the first got too old", n)
+	}
+	wantLines(t, replayAll(t, s), 0, 10)
+}
+
+func TestTotalSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Config{MaxSegmentBytes: 300, MaxTotalBytes: 900})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 0, 50)
+
+	var total int64
+	for _, path := range segmentFiles(t, dir) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += fi.Size()
+	}
+	// the limit is enforced before each append, so the spool can go over by
+	// at most one segment
+	if total > 900+300 {
+		t.Errorf("spool holds %d bytes, want about 900", total)
+	}
+	if s.Dropped() == 0 {
+		t.Fatal("nothing was dropped")
+	}
+	if s.Len()+s.Dropped() != 50 {
+		t.Errorf("Len %d + Dropped %d != 50 appended", s.Len(), s.Dropped())
+	}
+
+	// the oldest lines are the ones dropped
+	wantLines(t, replayAll(t, s), s.Dropped(), 50)
+}
+
+func TestAgeLimit(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Config{MaxSegmentBytes: 300, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 0, 20)
+	old := len(s.segments) - 1
+	for _, seg := range s.segments[:old] {
+		seg.modified = time.Now().Add(-2 * time.Hour)
+	}
+	var dropped int64
+	for _, seg := range s.segments[:old] {
+		dropped += seg.records
+	}
+
+	appendN(t, s, 20, 21)
+	if int64(s.Dropped()) != dropped {
+		t.Errorf("Dropped is %d, want the %d lines in segments older than MaxAge", s.Dropped(), dropped)
+	}
+	wantLines(t, replayAll(t, s), int(dropped), 21)
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{MaxSegmentBytes: 300}
+
+	s, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 0, 20)
+
+	// deliver some, then fail part way through
+	var lines []string
+	errStop := fmt.Errorf("collector went away")
+	if err := s.Replay(func(rec Record) error {
+		if len(lines) == 12 {
+			return errStop
+		}
+		lines = append(lines, rec.Line)
+		return nil
+	}); err != errStop {
+		t.Fatalf("Replay returned %v, want %v", err, errStop)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// delivery is at-least-once, so we may see the start of the segment we
+	// were part way through again, but nothing from fully replayed ones
+	appendN(t, s, 20, 25)
+	got := replayAll(t, s)
+	if len(got) == 0 {
+		t.Fatal("nothing was replayed after reopening")
+	}
+	first, err := strconv.Atoi(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first > 12 {
+		t.Fatalf("replay after reopening starts at %d, losing undelivered lines", first)
+	}
+	wantLines(t, got, first, 25)
+}
+
+func TestTornWriteIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 0, 3)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// as if we crashed part way through a write
+	path := segmentFiles(t, dir)[0]
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, append(b, `{"host":"ho`...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	appendN(t, s, 3, 5)
+	wantLines(t, replayAll(t, s), 0, 5)
+}
@@ -0,0 +1,122 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// IssueDetails describes an issue; see Issue for what each field means.
+type IssueDetails struct {
+	Id          string
+	Severity    IssueSeverity
+	Title       string
+	Description string
+	Remediation string
+}
+
+// BasicIssue is an Issue made from IssueDetails, along with where and when
+// it was seen.
+type BasicIssue struct {
+	details IssueDetails
+
+	host      Host
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+func NewIssue(d IssueDetails, host Host, firstSeen, lastSeen time.Time) BasicIssue {
+	return BasicIssue{
+		details:   d,
+		host:      host,
+		firstSeen: firstSeen,
+		lastSeen:  lastSeen,
+	}
+}
+
+func (bi BasicIssue) Id() string {
+	return bi.details.Id
+}
+
+func (bi BasicIssue) Severity() IssueSeverity {
+	return bi.details.Severity
+}
+
+func (bi BasicIssue) Title() string {
+	return bi.details.Title
+}
+
+func (bi BasicIssue) Description() string {
+	return bi.details.Description
+}
+
+func (bi BasicIssue) FirstSeen() time.Time {
+	return bi.firstSeen
+}
+
+func (bi BasicIssue) LastSeen() time.Time {
+	return bi.lastSeen
+}
+
+func (bi BasicIssue) Host() Host {
+	return bi.host
+}
+
+func (bi BasicIssue) Remediation() string {
+	return bi.details.Remediation
+}
+
+// IssueTracker remembers when each of a reporter's issues was first seen,
+// for as long as it keeps being reported.
+type IssueTracker struct {
+	host Host
+
+	mu        sync.Mutex
+	firstSeen map[string]time.Time
+	last      []Issue
+}
+
+func NewIssueTracker(host Host) *IssueTracker {
+	return &IssueTracker{
+		host:      host,
+		firstSeen: map[string]time.Time{},
+		last:      []Issue{},
+	}
+}
+
+// Issues turns ds, the issues seen at now, into Issues, and forgets about any
+// issue which isn't among them any more.
+func (it *IssueTracker) Issues(ds []IssueDetails, now time.Time) []Issue {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	current := map[string]bool{}
+	issues := make([]Issue, len(ds))
+	for n, d := range ds {
+		firstSeen, ok := it.firstSeen[d.Id]
+		if !ok {
+			firstSeen = now
+			it.firstSeen[d.Id] = now
+		}
+		current[d.Id] = true
+		issues[n] = NewIssue(d, it.host, firstSeen, now)
+	}
+
+	// forget about issues which have cleared up
+	for id := range it.firstSeen {
+		if !current[id] {
+			delete(it.firstSeen, id)
+		}
+	}
+
+	it.last = issues
+	return issues
+}
+
+// Last returns the issues from the last call to Issues, for when a reporter
+// can't currently tell whether they still apply.
+func (it *IssueTracker) Last() []Issue {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	return it.last
+}
@@ -0,0 +1,124 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReporterOptions are the settings for a single reporter, as given in the
+// configuration file. The accessors return def if key is not set.
+type ReporterOptions map[string]interface{}
+
+func (ro ReporterOptions) Int(key string, def int) (int, error) {
+	v, ok := ro[key]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("option %s: expected an integer, got %v", key, v)
+}
+
+func (ro ReporterOptions) Float(key string, def float64) (float64, error) {
+	v, ok := ro[key]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("option %s: expected a number, got %v", key, v)
+}
+
+func (ro ReporterOptions) Bool(key string, def bool) (bool, error) {
+	v, ok := ro[key]
+	if !ok {
+		return def, nil
+	}
+
+	if b, ok := v.(bool); ok {
+		return b, nil
+	}
+	return false, fmt.Errorf("option %s: expected a boolean, got %v", key, v)
+}
+
+func (ro ReporterOptions) String(key string, def string) (string, error) {
+	v, ok := ro[key]
+	if !ok {
+		return def, nil
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("option %s: expected a string, got %v", key, v)
+}
+
+func (ro ReporterOptions) Strings(key string, def []string) ([]string, error) {
+	v, ok := ro[key]
+	if !ok {
+		return def, nil
+	}
+
+	switch v := v.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		ss := make([]string, len(v))
+		for n, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %s: expected a list of strings, got %v", key, v)
+			}
+			ss[n] = s
+		}
+		return ss, nil
+	}
+	return nil, fmt.Errorf("option %s: expected a list of strings, got %v", key, v)
+}
+
+func (ro ReporterOptions) Duration(key string, def time.Duration) (time.Duration, error) {
+	s, err := ro.String(key, "")
+	if err != nil || s == "" {
+		return def, err
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// Threshold reads a pair of warning and danger levels from key_warning and
+// key_danger.
+func (ro ReporterOptions) Threshold(key string, def Threshold) (Threshold, error) {
+	var t Threshold
+	var err error
+	if t.Warning, err = ro.Int(key+"_warning", def.Warning); err != nil {
+		return Threshold{}, err
+	}
+	if t.Danger, err = ro.Int(key+"_danger", def.Danger); err != nil {
+		return Threshold{}, err
+	}
+	return t, nil
+}
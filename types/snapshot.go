@@ -0,0 +1,61 @@
+package types
+
+// A Snapshot holds what a host's reporters returned at one point in time, so
+// that it can be handed on more than once without asking the reporters
+// again.
+type Snapshot struct {
+	Host      Host
+	Reporters []ReporterSnapshot
+}
+
+type ReporterSnapshot struct {
+	Id      string
+	Issues  []Issue
+	Metrics []Metric
+	Hosts   []*Snapshot
+}
+
+// TakeSnapshot asks each of h's reporters, along with any extra reporters the
+// caller wants to include, for what they currently know.
+func TakeSnapshot(h Host, extra ...Reporter) (*Snapshot, error) {
+	reporters, err := h.Reporters()
+	if err != nil {
+		return nil, err
+	}
+	reporters = append(reporters[:len(reporters):len(reporters)], extra...)
+
+	s := &Snapshot{
+		Host:      h,
+		Reporters: make([]ReporterSnapshot, len(reporters)),
+	}
+	for n, r := range reporters {
+		if s.Reporters[n], err = TakeReporterSnapshot(r); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func TakeReporterSnapshot(r Reporter) (ReporterSnapshot, error) {
+	var err error
+	rs := ReporterSnapshot{Id: r.Id()}
+
+	if rs.Issues, err = r.Issues(); err != nil {
+		return ReporterSnapshot{}, err
+	}
+	if rs.Metrics, err = r.Metrics(); err != nil {
+		return ReporterSnapshot{}, err
+	}
+
+	hosts, err := r.Hosts()
+	if err != nil {
+		return ReporterSnapshot{}, err
+	}
+	rs.Hosts = make([]*Snapshot, len(hosts))
+	for n, h := range hosts {
+		if rs.Hosts[n], err = TakeSnapshot(h); err != nil {
+			return ReporterSnapshot{}, err
+		}
+	}
+	return rs, nil
+}
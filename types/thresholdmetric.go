@@ -0,0 +1,81 @@
+package types
+
+import (
+	"fmt"
+)
+
+// Threshold is a pair of configured warning and danger levels.
+type Threshold struct {
+	Warning int
+	Danger  int
+}
+
+// ThresholdMetric is a single reading, optionally checked against a
+// Threshold. Without one, it is a plain gauge and has no status.
+type ThresholdMetric struct {
+	id        string
+	humanName string
+	humanDesc string
+
+	value int
+
+	threshold *Threshold
+}
+
+func NewThresholdMetric(id, humanName, humanDesc string, value int, t *Threshold) ThresholdMetric {
+	return ThresholdMetric{
+		id:        id,
+		humanName: humanName,
+		humanDesc: humanDesc,
+		value:     value,
+		threshold: t,
+	}
+}
+
+// NewGaugeMetric is a ThresholdMetric which is never checked against a
+// threshold.
+func NewGaugeMetric(id, humanName, humanDesc string, value int) ThresholdMetric {
+	return NewThresholdMetric(id, humanName, humanDesc, value, nil)
+}
+
+func (tm ThresholdMetric) Id() string {
+	return tm.id
+}
+
+func (ThresholdMetric) MetricType() MetricType {
+	return METRICTYPE_UNCOUNTABLE
+}
+
+func (tm ThresholdMetric) Value() int {
+	return tm.value
+}
+
+func (tm ThresholdMetric) Status() MetricStatus {
+	switch {
+	case tm.threshold == nil:
+		return METRICSTATUS_NONE
+	case tm.value < tm.threshold.Warning:
+		return METRICSTATUS_HEALTHY
+	case tm.value < tm.threshold.Danger:
+		return METRICSTATUS_WARNING
+	default:
+		return METRICSTATUS_DANGER
+	}
+}
+
+func (tm ThresholdMetric) HumanName() string {
+	return tm.humanName
+}
+
+func (tm ThresholdMetric) HumanDesc() string {
+	switch {
+	case tm.threshold == nil:
+		return tm.humanDesc
+	case tm.value < tm.threshold.Warning:
+		return fmt.Sprintf(`%s This metric is healthy because the current level is below the configured warning level of %d.`, tm.humanDesc, tm.threshold.Warning)
+	case tm.value < tm.threshold.Danger:
+		return fmt.Sprintf(`%s This metric is warning because the current level is between the configured warning level of %d and the danger level of %d.`, tm.humanDesc, tm.threshold.Warning, tm.threshold.Danger)
+	default:
+		return fmt.Sprintf(`%s This metric is alerting because the current level is above the danger level of %d.`, tm.humanDesc, tm.threshold.Danger)
+	}
+}
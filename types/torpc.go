@@ -24,6 +24,34 @@ func HostToRPC(h Host) (*pb.Host, error) {
 	}, err
 }
 
+// ReportToRPC builds a report on h from its reporters, along with any extra
+// reporters the caller wants to include.
+func ReportToRPC(h Host, extra ...Reporter) (*pb.ReportRequest, error) {
+	s, err := TakeSnapshot(h, extra...)
+	if err != nil {
+		return nil, err
+	}
+	return SnapshotToRPC(s)
+}
+
+func SnapshotToRPC(s *Snapshot) (*pb.ReportRequest, error) {
+	var err error
+	rep := new(pb.ReportRequest)
+
+	rep.Host, err = HostToRPC(s.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	rep.Reporters = make([]*pb.Reporter, len(s.Reporters))
+	for n, rs := range s.Reporters {
+		if rep.Reporters[n], err = ReporterSnapshotToRPC(rs); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
 func ReportersToRPC(rs []Reporter) ([]*pb.Reporter, error) {
 	prs := make([]*pb.Reporter, len(rs))
 	for n, r := range rs {
@@ -37,42 +65,77 @@ func ReportersToRPC(rs []Reporter) ([]*pb.Reporter, error) {
 }
 
 func ReporterToRPC(r Reporter) (*pb.Reporter, error) {
+	rs, err := TakeReporterSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	return ReporterSnapshotToRPC(rs)
+}
+
+func ReporterSnapshotToRPC(rs ReporterSnapshot) (*pb.Reporter, error) {
+	var err error
 	pr := new(pb.Reporter)
+	pr.Id = rs.Id
 
-	if issues, err := r.Issues(); err != nil {
+	if pr.Issues, err = IssuesToRPC(rs.Issues); err != nil {
 		return nil, err
-	} else {
-		pr.Issues, err = IssuesToRPC(issues)
-		if err != nil {
-			return nil, err
-		}
 	}
-
-	if metrics, err := r.Metrics(); err != nil {
+	if pr.Metrics, err = MetricsToRPC(rs.Metrics); err != nil {
 		return nil, err
-	} else {
-		pr.Metrics, err = MetricsToRPC(metrics)
-		if err != nil {
+	}
+
+	pr.Hosts = make([]*pb.ReportRequest, len(rs.Hosts))
+	for n, s := range rs.Hosts {
+		if pr.Hosts[n], err = SnapshotToRPC(s); err != nil {
 			return nil, err
 		}
 	}
 
-	// TODO(lukegb): hosts
-
 	return pr, nil
 }
 
+// IssuesToRPC needs the Issue fields in proto/issue.proto, which are not yet
+// in github.com/icphalanx/rpc.
 func IssuesToRPC(is []Issue) ([]*pb.Issue, error) {
 	pis := make([]*pb.Issue, len(is))
 	for n, i := range is {
 		pis[n] = new(pb.Issue)
-		pis[n].Id = "???"
-		_ = i
-		// TODO(lukegb)
+		pis[n].Id = i.Id()
+		pis[n].Severity = IssueSeverityToRPC(i.Severity())
+		pis[n].Title = i.Title()
+		pis[n].Description = i.Description()
+		pis[n].Remediation = i.Remediation()
+
+		if t := i.FirstSeen(); !t.IsZero() {
+			pis[n].FirstSeen = TimeToGoogleTimestamp(t)
+		}
+		if t := i.LastSeen(); !t.IsZero() {
+			pis[n].LastSeen = TimeToGoogleTimestamp(t)
+		}
+
+		if h := i.Host(); h != nil {
+			hn, err := h.HumanName()
+			if err != nil {
+				return nil, err
+			}
+			pis[n].Host = hn
+		}
 	}
 	return pis, nil
 }
 
+func IssueSeverityToRPC(s IssueSeverity) pb.Issue_Severity {
+	switch s {
+	case ISSUESEVERITY_INFO:
+		return pb.Issue_INFO
+	case ISSUESEVERITY_WARNING:
+		return pb.Issue_WARNING
+	case ISSUESEVERITY_CRITICAL:
+		return pb.Issue_CRITICAL
+	}
+	return pb.Issue_UNKNOWN
+}
+
 func MetricsToRPC(ms []Metric) ([]*pb.Metric, error) {
 	pms := make([]*pb.Metric, len(ms))
 	for n, m := range ms {
@@ -101,6 +164,28 @@ func MetricTypeToRPC(m MetricType) pb.Metric_Type {
 	return pb.Metric_UNKNOWN
 }
 
+func LogLineToRPC(ll ReporterLogLine) (*pb.LogLine, error) {
+	hn, err := ll.Host.HumanName()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.LogLine{
+		Reporter:  ll.Reporter.Id(),
+		Timestamp: TimeToGoogleTimestamp(ll.Timestamp),
+		Line:      ll.LogLine,
+		Host:      hn,
+		Tags:      ll.Tags,
+	}, nil
+}
+
+func GoogleTimestampToTime(ts *google_protobuf.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos))
+}
+
 func TimeToGoogleTimestamp(t time.Time) *google_protobuf.Timestamp {
 	return &google_protobuf.Timestamp{
 		t.Unix(), int32(t.Nanosecond()),
@@ -15,6 +15,22 @@ type Host interface {
 }
 
 type Issue interface {
+	Id() string
+
+	Severity() IssueSeverity
+
+	Title() string
+	Description() string
+
+	// when this issue was first and most recently observed
+	FirstSeen() time.Time
+	LastSeen() time.Time
+
+	// the host this issue affects
+	Host() Host
+
+	// a human-readable suggestion for how to resolve this issue
+	Remediation() string
 }
 
 type Metric interface {
@@ -47,6 +63,16 @@ const (
 	METRICTYPE_STRINGARRAY
 )
 
+func (mt MetricType) String() string {
+	switch mt {
+	case METRICTYPE_UNCOUNTABLE:
+		return "uncountable"
+	case METRICTYPE_STRINGARRAY:
+		return "stringarray"
+	}
+	return "unknown"
+}
+
 type MetricStatus uint
 
 const (
@@ -56,12 +82,48 @@ const (
 	METRICSTATUS_DANGER
 )
 
+func (ms MetricStatus) String() string {
+	switch ms {
+	case METRICSTATUS_NONE:
+		return "none"
+	case METRICSTATUS_HEALTHY:
+		return "healthy"
+	case METRICSTATUS_WARNING:
+		return "warning"
+	case METRICSTATUS_DANGER:
+		return "danger"
+	}
+	return "unknown"
+}
+
+type IssueSeverity uint
+
+const (
+	ISSUESEVERITY_UNKNOWN = iota
+	ISSUESEVERITY_INFO
+	ISSUESEVERITY_WARNING
+	ISSUESEVERITY_CRITICAL
+)
+
+func (is IssueSeverity) String() string {
+	switch is {
+	case ISSUESEVERITY_INFO:
+		return "info"
+	case ISSUESEVERITY_WARNING:
+		return "warning"
+	case ISSUESEVERITY_CRITICAL:
+		return "critical"
+	}
+	return "unknown"
+}
+
 type ReporterFactory interface {
 	Id() string
 
-	ApplicableTo(Host) (bool, error)
-
-	Create(Host) (Reporter, error)
+	// opts are the reporter's settings from the configuration file, and may
+	// be nil
+	ApplicableTo(Host, ReporterOptions) (bool, error)
+	Create(Host, ReporterOptions) (Reporter, error)
 }
 
 type Reporter interface {